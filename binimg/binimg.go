@@ -6,14 +6,9 @@ import (
 )
 
 // GenImages generates a list of images by attaching the binary image
-// to the left of the source image.
+// to the left of the source image, that is Encode with a LeftBar.
 func GenImages(src image.Image, count, width int) []image.Image {
-	images := make([]image.Image, count)
-	for pos := 0; pos < count; pos++ {
-		img := ExpandLeft(src, width*count)
-		images[pos] = MarkPosition(img, width, pos)
-	}
-	return images
+	return Encode(src, count, LeftBar{Width: width})
 }
 
 // ExpandLeft expands the image to the left by width pixels.
@@ -0,0 +1,138 @@
+package binimg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+type (
+	// PositionEncoder marks on the image which character of the captcha
+	// the model should read. Training and inference must use the same one.
+	PositionEncoder interface {
+		// Encode returns a copy of the image marked for the position pos
+		// of a captcha of count characters.
+		Encode(src image.Image, count, pos int) image.Image
+	}
+	// LeftBar expands the image to the left by Width*count pixels, and marks
+	// the position with a black bar at Width*pos. It is the default encoder.
+	LeftBar struct {
+		Width int
+	}
+	// TopBar expands the image to the top by Height pixels, and marks the
+	// position with a black bar above the pos-th of count equal columns.
+	TopBar struct {
+		Height int
+	}
+	// ChannelEncoder overwrites the blue channel with a one-hot code of
+	// the position: the pos-th of count equal columns is full, the others
+	// are empty. The size of the image is unchanged.
+	ChannelEncoder struct{}
+	// BandEncoder highlights the pos-th of count equal columns, where the
+	// character is expected, by blending it with the premultiplied Color.
+	BandEncoder struct {
+		Color color.RGBA
+	}
+)
+
+const (
+	// DefaultEncoderSpec is the spec of the default PositionEncoder.
+	DefaultEncoderSpec = "left=10"
+	// EncoderUsage describes the specs of ParseEncoder, for command flags.
+	EncoderUsage = "Position encoder: left=<width>, top=<height>, channel or band"
+)
+
+// ParseEncoder returns the PositionEncoder of the spec, which is one of
+// "left=<width>", "top=<height>", "channel" or "band". It is the one place
+// commands and the engine should get the encoder from.
+func ParseEncoder(spec string) (PositionEncoder, error) {
+	name, arg, _ := strings.Cut(spec, "=")
+	size := func(fallback int) (int, error) {
+		if arg == "" {
+			return fallback, nil
+		}
+		return strconv.Atoi(arg)
+	}
+	switch name {
+	case "left":
+		w, err := size(10)
+		return LeftBar{Width: w}, err
+	case "top":
+		h, err := size(10)
+		return TopBar{Height: h}, err
+	case "channel":
+		return ChannelEncoder{}, nil
+	case "band":
+		return BandEncoder{Color: color.RGBA{0x80, 0, 0, 0x80}}, nil
+	}
+	return nil, fmt.Errorf("binimg: unknown position encoder %q", spec)
+}
+
+// Encode generates an image per position with the encoder.
+func Encode(src image.Image, count int, enc PositionEncoder) []image.Image {
+	images := make([]image.Image, count)
+	for pos := range images {
+		images[pos] = enc.Encode(src, count, pos)
+	}
+	return images
+}
+
+// Encode implements the PositionEncoder interface.
+func (e LeftBar) Encode(src image.Image, count, pos int) image.Image {
+	return MarkPosition(ExpandLeft(src, e.Width*count), e.Width, pos)
+}
+
+// Encode implements the PositionEncoder interface.
+func (e TopBar) Encode(src image.Image, count, pos int) image.Image {
+	sb := src.Bounds()
+	b := image.Rect(0, 0, sb.Dx(), sb.Dy()+e.Height)
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, b.Add(image.Pt(0, e.Height)), src, sb.Min, draw.Src)
+	x0, x1 := column(sb.Dx(), count, pos)
+	draw.Draw(dst, image.Rect(x0, 0, x1, e.Height), image.Black, image.Point{}, draw.Src)
+	return dst
+}
+
+// Encode implements the PositionEncoder interface.
+func (ChannelEncoder) Encode(src image.Image, count, pos int) image.Image {
+	dst := clone(src)
+	b := dst.Bounds()
+	x0, x1 := column(b.Dx(), count, pos)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := dst.PixOffset(x, y)
+			// Premultiplied, so the channel can not exceed the alpha.
+			dst.Pix[i+2] = 0
+			if x-b.Min.X >= x0 && x-b.Min.X < x1 {
+				dst.Pix[i+2] = dst.Pix[i+3]
+			}
+		}
+	}
+	return dst
+}
+
+// Encode implements the PositionEncoder interface.
+func (e BandEncoder) Encode(src image.Image, count, pos int) image.Image {
+	dst := clone(src)
+	b := dst.Bounds()
+	x0, x1 := column(b.Dx(), count, pos)
+	r := image.Rect(b.Min.X+x0, b.Min.Y, b.Min.X+x1, b.Max.Y)
+	draw.Draw(dst, r, image.NewUniform(e.Color), image.Point{}, draw.Over)
+	return dst
+}
+
+// column returns the range of the pos-th of count equal columns of width.
+func column(width, count, pos int) (int, int) {
+	return width * pos / count, width * (pos + 1) / count
+}
+
+func clone(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, src, b.Min, draw.Src)
+	return dst
+}
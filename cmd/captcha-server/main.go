@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"giautm.dev/captcha/binimg"
+	"giautm.dev/captcha/engine"
+	"giautm.dev/captcha/fisheye"
+	"giautm.dev/captcha/knnsymbol"
+	"giautm.dev/captcha/labeled"
+	"giautm.dev/captcha/segment"
+	"giautm.dev/captcha/tfsymbol"
+	"go.opencensus.io/stats/view"
+)
+
+var (
+	addr         = flag.String("addr", ":"+envOr("PORT", "8080"), "Address to listen on")
+	servingURL   = flag.String("serving", "http://localhost:8501", "Base URL of the TensorFlow Serving server")
+	modelName    = flag.String("model", "resnet", "Name of the model to use for prediction")
+	labelsFile   = flag.String("labels", "./labels.txt", "File with one label per line")
+	knnIndex     = flag.String("knnIndex", "", "Index file of the local nearest-neighbour resolver, replaces TensorFlow Serving and the encoder by segmentation")
+	encoder      = flag.String("encoder", binimg.DefaultEncoderSpec, binimg.EncoderUsage)
+	minLen       = flag.Int("minLen", labeled.DefaultPositions, "Minimum length of captchas")
+	maxLen       = flag.Int("maxLen", labeled.DefaultPositions, "Maximum length of captchas, the number of encoded positions")
+	blank        = flag.String("blank", labeled.DefaultBlank, "Label the model gives to the positions past the end of shorter captchas")
+	charset      = flag.String("charset", "", "Characters allowed in captchas, empty to allow any")
+	pattern      = flag.String("pattern", "", "Regular expression captchas must match as a whole, empty to allow any")
+	testRow      = flag.Int("testRow", fisheye.AutoTestRow, "Row scored to find the fisheye distance, -1 to select it from the image")
+	cacheSize    = flag.Int("cacheSize", 1024, "Number of results cached in memory, 0 to disable the cache")
+	cacheTTL     = flag.Duration("cacheTTL", 10*time.Minute, "Time results are cached, 0 to cache them until evicted")
+	cacheDir     = flag.String("cacheDir", "", "Directory where results are also cached, empty to disable")
+	feedbackDir  = flag.String("feedbackDir", "", "Data directory where reported captchas are saved, empty to disable")
+	maxBodySize  = flag.Int64("maxBodySize", 1<<20, "Maximum size of request bodies in bytes")
+	timeout      = flag.Duration("timeout", 10*time.Second, "Timeout of requests to the TensorFlow Serving server")
+	shutdownWait = flag.Duration("shutdownWait", 15*time.Second, "Time to wait for in-flight requests on shutdown")
+)
+
+type (
+	server struct {
+		resolver engine.CaptchaResolver
+		reporter engine.ResultReporter
+		ready    func(context.Context) error
+	}
+	// multiReporter reports the results to all of its reporters.
+	multiReporter []engine.ResultReporter
+	reportRequest struct {
+		Result  *engine.CaptchaResult `json:"result"`
+		Correct bool                  `json:"correct"`
+		// Image is the captcha image, it is required by the feedback store.
+		Image []byte `json:"image,omitempty"`
+	}
+	errorResponse struct {
+		Error string `json:"error"`
+	}
+)
+
+func main() {
+	flag.Parse()
+	sr, ready, err := newSymbolResolver()
+	if err != nil {
+		log.Fatalf("create symbol resolver: %v", err)
+	}
+	enc, err := binimg.ParseEncoder(*encoder)
+	if err != nil {
+		log.Fatalf("parse encoder: %v", err)
+	}
+	p := fisheye.NewPreprocessor()
+	if *testRow != fisheye.AutoTestRow {
+		p.TestRowIndex = *testRow
+	}
+	opts := []engine.Option{
+		engine.WithPreprocessor(p),
+		engine.WithPositionEncoder(enc),
+		engine.WithCaptchaLengthRange(*minLen, *maxLen),
+		engine.WithBlankLabel(*blank),
+		engine.WithSymbolResolver(sr),
+		engine.WithStats(),
+	}
+	if *charset != "" {
+		opts = append(opts, engine.WithCharset(*charset))
+	}
+	if *pattern != "" {
+		opts = append(opts, engine.WithPattern(*pattern))
+	}
+	if *knnIndex != "" {
+		// The index is built from the glyphs of single characters.
+		count := 0
+		if *minLen == *maxLen {
+			count = *maxLen
+		}
+		opts = append(opts, engine.WithSplitter(segment.NewSplitter(count)))
+	}
+	e, err := engine.NewCaptchaResolveEngine(opts...)
+	if err != nil {
+		log.Fatalf("create engine: %v", err)
+	}
+	var resolver engine.CaptchaResolver = e
+	if *cacheSize > 0 {
+		opts := []engine.CacheOption{engine.WithCacheSize(*cacheSize), engine.WithCacheTTL(*cacheTTL)}
+		if *cacheDir != "" {
+			opts = append(opts, engine.WithCacheStore(&engine.DiskStore{Dir: *cacheDir, TTL: *cacheTTL}))
+		}
+		c, err := engine.NewCachedResolver(resolver, opts...)
+		if err != nil {
+			log.Fatalf("create cache: %v", err)
+		}
+		resolver = c
+	}
+	stats := &engine.StatsCaptchaResolver{CaptchaResolver: resolver}
+	s := &server{
+		resolver: stats,
+		reporter: stats,
+		ready:    ready,
+	}
+	if *feedbackDir != "" {
+		s.reporter = multiReporter{stats, labeled.NewReporter(*feedbackDir)}
+	}
+	if err := view.Register(engine.DefaultViews...); err != nil {
+		log.Fatalf("register views: %v", err)
+	}
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		log.Printf("listening on %s", *addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("listen: %v", err)
+		}
+	}()
+	<-ctx.Done()
+	log.Print("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownWait)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Fatalf("shutdown: %v", err)
+	}
+}
+
+// newSymbolResolver returns the local resolver if an index is given,
+// otherwise the TensorFlow Serving one, with its readiness check.
+func newSymbolResolver() (engine.SymbolResolver, func(context.Context) error, error) {
+	if *knnIndex != "" {
+		ix, err := knnsymbol.LoadFile(*knnIndex)
+		if err != nil {
+			return nil, nil, err
+		}
+		sr, err := knnsymbol.NewResolver(ix)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sr, func(context.Context) error { return nil }, nil
+	}
+	labels, err := tfsymbol.ReadLabels(*labelsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	sr, err := tfsymbol.NewRemoteResolver(labels,
+		tfsymbol.WithBaseURL(*servingURL),
+		tfsymbol.WithModelName(*modelName),
+		tfsymbol.WithHTTPClient(&http.Client{Timeout: *timeout}),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sr, sr.Ready, nil
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /resolve", s.resolve)
+	mux.HandleFunc("POST /report", s.report)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.Handle("GET /metrics", metricsHandler(engine.DefaultViews))
+	return mux
+}
+
+// resolve accepts the captcha image as the raw body, or as the "image"
+// field of a multipart form.
+func (s *server) resolve(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, *maxBodySize)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			writeError(w, requestErrorStatus(err), err)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+	source, err := io.ReadAll(body)
+	if err != nil {
+		writeError(w, requestErrorStatus(err), err)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	result, err := s.resolver.ResolveImage(r.Context(), img)
+	if err != nil {
+		writeError(w, resolveErrorStatus(err), err)
+		return
+	}
+	result.Source = source
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) report(w http.ResponseWriter, r *http.Request) {
+	if s.reporter == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("reporting is not supported"))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, *maxBodySize)
+	var req reportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, requestErrorStatus(err), err)
+		return
+	}
+	if req.Result == nil {
+		writeError(w, http.StatusBadRequest, errors.New("result is required"))
+		return
+	}
+	if len(req.Image) > 0 {
+		req.Result.Source = req.Image
+	}
+	// A report rejected by any reporter is rejected before it is counted
+	// by the others, so that the client can fix and retry it.
+	if v, ok := s.reporter.(engine.ReportValidator); ok {
+		if err := v.ValidateReport(req.Result, req.Correct); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	switch err := s.reporter.Report(r.Context(), req.Result, req.Correct); {
+	case errors.Is(err, labeled.ErrNoSource), errors.Is(err, labeled.ErrInvalidCaptcha),
+		errors.Is(err, engine.ErrNoCacheKey):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (m multiReporter) Report(ctx context.Context, result *engine.CaptchaResult, correct bool) error {
+	var errs []error
+	for _, r := range m {
+		errs = append(errs, r.Report(ctx, result, correct))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateReport implements the engine.ReportValidator interface.
+func (m multiReporter) ValidateReport(result *engine.CaptchaResult, correct bool) error {
+	for _, r := range m {
+		if v, ok := r.(engine.ReportValidator); ok {
+			if err := v.ValidateReport(result, correct); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *server) readyz(w http.ResponseWriter, r *http.Request) {
+	if err := s.ready(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func requestErrorStatus(err error) int {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// resolveErrorStatus returns 422 for the captchas the engine could read
+// but not accept, and 502 for the failures of the resolver.
+func resolveErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, engine.ErrCaptchaLength), errors.Is(err, engine.ErrCaptchaConstraint):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, fisheye.ErrDetectDistance):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("write response: %v", err)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"giautm.dev/captcha/engine"
+	"giautm.dev/captcha/fisheye"
+	"giautm.dev/captcha/labeled"
+)
+
+// stubResolver resolves every image to the captcha, or fails with err.
+type stubResolver struct {
+	captcha string
+	err     error
+}
+
+func (r stubResolver) ResolveFile(ctx context.Context, f io.Reader) (*engine.CaptchaResult, error) {
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return r.ResolveImage(ctx, img)
+}
+
+func (r stubResolver) ResolveImage(context.Context, image.Image) (*engine.CaptchaResult, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &engine.CaptchaResult{Captcha: r.captcha, Length: len(r.captcha)}, nil
+}
+
+// countingReporter counts the reports, and fails them with err.
+type countingReporter struct {
+	reports int
+	err     error
+}
+
+func (r *countingReporter) Report(context.Context, *engine.CaptchaResult, bool) error {
+	r.reports++
+	return r.err
+}
+
+func pngBytes(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func multipartBody(t *testing.T, field string, data []byte) (io.Reader, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	fw, err := mw.CreateFormFile(field, "captcha.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func TestResolve(t *testing.T) {
+	source := pngBytes(t)
+	tests := []struct {
+		name        string
+		resolver    stubResolver
+		body        func(t *testing.T) (io.Reader, string)
+		maxBodySize int64
+		wantStatus  int
+	}{
+		{
+			name:       "raw",
+			resolver:   stubResolver{captcha: "abc"},
+			body:       func(*testing.T) (io.Reader, string) { return bytes.NewReader(source), "image/png" },
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:     "multipart",
+			resolver: stubResolver{captcha: "abc"},
+			body: func(t *testing.T) (io.Reader, string) {
+				return multipartBody(t, "image", source)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:     "multipart without image",
+			resolver: stubResolver{captcha: "abc"},
+			body: func(t *testing.T) (io.Reader, string) {
+				return multipartBody(t, "file", source)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not an image",
+			resolver:   stubResolver{captcha: "abc"},
+			body:       func(*testing.T) (io.Reader, string) { return strings.NewReader("text"), "text/plain" },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "too large",
+			resolver:    stubResolver{captcha: "abc"},
+			body:        func(*testing.T) (io.Reader, string) { return bytes.NewReader(source), "image/png" },
+			maxBodySize: 8,
+			wantStatus:  http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "length",
+			resolver:   stubResolver{err: engine.ErrCaptchaLength},
+			body:       func(*testing.T) (io.Reader, string) { return bytes.NewReader(source), "image/png" },
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "constraint",
+			resolver:   stubResolver{err: &engine.ConstraintError{Captcha: "abc"}},
+			body:       func(*testing.T) (io.Reader, string) { return bytes.NewReader(source), "image/png" },
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "fisheye",
+			resolver:   stubResolver{err: fisheye.ErrDetectDistance},
+			body:       func(*testing.T) (io.Reader, string) { return bytes.NewReader(source), "image/png" },
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "resolver failed",
+			resolver:   stubResolver{err: errors.New("serving is down")},
+			body:       func(*testing.T) (io.Reader, string) { return bytes.NewReader(source), "image/png" },
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxBodySize > 0 {
+				defer func(n int64) { *maxBodySize = n }(*maxBodySize)
+				*maxBodySize = tt.maxBodySize
+			}
+			s := &server{resolver: tt.resolver}
+			body, contentType := tt.body(t)
+			req := httptest.NewRequest(http.MethodPost, "/resolve", body)
+			req.Header.Set("Content-Type", contentType)
+			rec := httptest.NewRecorder()
+			s.routes().ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var result engine.CaptchaResult
+			if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Captcha != tt.resolver.captcha {
+				t.Errorf("captcha = %q, want %q", result.Captcha, tt.resolver.captcha)
+			}
+		})
+	}
+}
+
+func TestReport(t *testing.T) {
+	source := pngBytes(t)
+	result := func(captcha string) *engine.CaptchaResult {
+		return &engine.CaptchaResult{Captcha: captcha, Key: strings.Repeat("0", 64)}
+	}
+	tests := []struct {
+		name string
+		// feedback adds a labeled.Reporter saving the incorrect results.
+		feedback    bool
+		reporterErr error
+		noReporter  bool
+		body        any
+		wantStatus  int
+		wantReports int
+	}{
+		{
+			name:        "reported",
+			body:        reportRequest{Result: result("abc")},
+			wantStatus:  http.StatusNoContent,
+			wantReports: 1,
+		},
+		{
+			name:       "no result",
+			body:       reportRequest{},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "invalid json",
+			body:       "{",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "not supported",
+			noReporter: true,
+			body:       reportRequest{Result: result("abc")},
+			wantStatus: http.StatusNotImplemented,
+		},
+		{
+			name:        "feedback with image",
+			feedback:    true,
+			body:        reportRequest{Result: result("abc"), Image: source},
+			wantStatus:  http.StatusNoContent,
+			wantReports: 1,
+		},
+		{
+			name:       "feedback without image",
+			feedback:   true,
+			body:       reportRequest{Result: result("abc")},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "feedback invalid captcha",
+			feedback:   true,
+			body:       reportRequest{Result: result("../abc"), Image: source},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "reporter failed",
+			reporterErr: errors.New("disk full"),
+			body:        reportRequest{Result: result("abc")},
+			wantStatus:  http.StatusInternalServerError,
+			wantReports: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := &countingReporter{err: tt.reporterErr}
+			s := &server{reporter: counter}
+			if tt.feedback {
+				s.reporter = multiReporter{counter, &labeled.Reporter{IncorrectDir: t.TempDir()}}
+			}
+			if tt.noReporter {
+				s.reporter = nil
+			}
+			data, ok := tt.body.(string)
+			if !ok {
+				b, err := json.Marshal(tt.body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data = string(b)
+			}
+			rec := httptest.NewRecorder()
+			s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/report", strings.NewReader(data)))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if counter.reports != tt.wantReports {
+				t.Errorf("reported %d times, want %d", counter.reports, tt.wantReports)
+			}
+		})
+	}
+}
+
+// TestReportCacheKey checks that an incorrect report the cache can not
+// evict is rejected before the feedback store saves it.
+func TestReportCacheKey(t *testing.T) {
+	cache, err := engine.NewCachedResolver(stubResolver{captcha: "abc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	s := &server{reporter: multiReporter{
+		&engine.StatsCaptchaResolver{CaptchaResolver: cache},
+		&labeled.Reporter{IncorrectDir: dir},
+	}}
+	body := `{"result":{"captcha":"abc","key":"../x"},"image":"` + "aGVsbG8=" + `"}`
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/report", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), engine.ErrNoCacheKey.Error()) {
+		t.Errorf("error = %s, want %v", rec.Body, engine.ErrNoCacheKey)
+	}
+	if entries, _ := filepath.Glob(dir + "/*"); len(entries) > 0 {
+		t.Errorf("saved %v, want the report rejected first", entries)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{"ready", nil, http.StatusOK},
+		{"not ready", errors.New("model is loading"), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{ready: func(context.Context) error { return tt.err }}
+			rec := httptest.NewRecorder()
+			s.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// metricsHandler serves the registered views in the Prometheus text
+// exposition format. The views are read on each scrape.
+func metricsHandler(views []*view.View) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, v := range views {
+			rows, err := view.RetrieveData(v.Name)
+			if err != nil {
+				log.Printf("retrieve view %s: %v", v.Name, err)
+				continue
+			}
+			writeView(bw, v, rows)
+		}
+		bw.Flush()
+	})
+}
+
+func writeView(w *bufio.Writer, v *view.View, rows []*view.Row) {
+	name := metricName(v.Name)
+	typ := "gauge"
+	switch v.Aggregation.Type {
+	case view.AggTypeCount:
+		typ, name = "counter", name+"_total"
+	case view.AggTypeSum:
+		typ = "counter"
+	case view.AggTypeDistribution:
+		typ = "histogram"
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", name, escapeHelp(v.Description))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+	// The rows are in no particular order, sort them by their labels.
+	slices.SortFunc(rows, func(a, b *view.Row) int {
+		return strings.Compare(formatLabels(labelPairs(a.Tags)), formatLabels(labelPairs(b.Tags)))
+	})
+	for _, row := range rows {
+		labels := labelPairs(row.Tags)
+		switch data := row.Data.(type) {
+		case *view.CountData:
+			fmt.Fprintf(w, "%s%s %d\n", name, formatLabels(labels), data.Value)
+		case *view.SumData:
+			fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels), formatFloat(data.Value))
+		case *view.LastValueData:
+			fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels), formatFloat(data.Value))
+		case *view.DistributionData:
+			var cumulative int64
+			for i, bound := range v.Aggregation.Buckets {
+				if i < len(data.CountPerBucket) {
+					cumulative += data.CountPerBucket[i]
+				}
+				// OpenCensus counts the values below the bound, and Prometheus
+				// up to it, so le is the float just below the bound.
+				le := append(labels[:len(labels):len(labels)], [2]string{"le", formatFloat(math.Nextafter(bound, math.Inf(-1)))})
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(le), cumulative)
+			}
+			le := append(labels[:len(labels):len(labels)], [2]string{"le", "+Inf"})
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatLabels(le), data.Count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", name, formatLabels(labels), formatFloat(data.Sum()))
+			fmt.Fprintf(w, "%s_count%s %d\n", name, formatLabels(labels), data.Count)
+		}
+	}
+}
+
+// metricName replaces the characters not allowed in metric names.
+func metricName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func labelPairs(tags []tag.Tag) [][2]string {
+	labels := make([][2]string, len(tags))
+	for i, t := range tags {
+		labels[i] = [2]string{metricName(t.Key.Name()), t.Value}
+	}
+	return labels
+}
+
+func formatLabels(labels [][2]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l[0])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(l[1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
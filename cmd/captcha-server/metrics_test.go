@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	keyResult := tag.MustNewKey("result")
+	keyModel := tag.MustNewKey("model.name")
+	tests := []struct {
+		name    string
+		agg     *view.Aggregation
+		keys    []tag.Key
+		records func(ctx context.Context, m *stats.Float64Measure)
+		want    string
+	}{
+		{
+			name: "count",
+			agg:  view.Count(),
+			keys: []tag.Key{keyResult},
+			records: func(ctx context.Context, m *stats.Float64Measure) {
+				for _, result := range []string{"ok", "error", "ok"} {
+					ctx, _ := tag.New(ctx, tag.Upsert(keyResult, result))
+					stats.Record(ctx, m.M(1))
+				}
+			},
+			want: "# HELP test_count_metric_total Metric of the \"count\" test.\\n\n" +
+				"# TYPE test_count_metric_total counter\n" +
+				"test_count_metric_total{result=\"error\"} 1\n" +
+				"test_count_metric_total{result=\"ok\"} 2\n",
+		},
+		{
+			name: "sum",
+			agg:  view.Sum(),
+			records: func(ctx context.Context, m *stats.Float64Measure) {
+				stats.Record(ctx, m.M(1.5), m.M(2))
+			},
+			want: "# HELP test_sum_metric Metric of the \"sum\" test.\\n\n" +
+				"# TYPE test_sum_metric counter\n" +
+				"test_sum_metric 3.5\n",
+		},
+		{
+			name: "last value",
+			agg:  view.LastValue(),
+			keys: []tag.Key{keyModel},
+			records: func(ctx context.Context, m *stats.Float64Measure) {
+				// OpenCensus only accepts printable values.
+				ctx, _ = tag.New(ctx, tag.Upsert(keyModel, `res"net\1`))
+				stats.Record(ctx, m.M(3), m.M(0.25))
+			},
+			want: "# HELP test_last_value_metric Metric of the \"last value\" test.\\n\n" +
+				"# TYPE test_last_value_metric gauge\n" +
+				`test_last_value_metric{model_name="res\"net\\1"} 0.25` + "\n",
+		},
+		{
+			name: "distribution",
+			agg:  view.Distribution(1, 2, 5),
+			records: func(ctx context.Context, m *stats.Float64Measure) {
+				// The values on the bounds count in the le of the bound.
+				stats.Record(ctx, m.M(1), m.M(2), m.M(3), m.M(10))
+			},
+			want: "# HELP test_distribution_metric Metric of the \"distribution\" test.\\n\n" +
+				"# TYPE test_distribution_metric histogram\n" +
+				"test_distribution_metric_bucket{le=\"0.9999999999999999\"} 0\n" +
+				"test_distribution_metric_bucket{le=\"1.9999999999999998\"} 1\n" +
+				"test_distribution_metric_bucket{le=\"4.999999999999999\"} 3\n" +
+				"test_distribution_metric_bucket{le=\"+Inf\"} 4\n" +
+				"test_distribution_metric_sum 16\n" +
+				"test_distribution_metric_count 4\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := "test/" + metricName(tt.name) + ".metric"
+			m := stats.Float64(name, "test measure", stats.UnitDimensionless)
+			v := &view.View{
+				Name:        name,
+				Description: "Metric of the \"" + tt.name + "\" test.\n",
+				Measure:     m,
+				TagKeys:     tt.keys,
+				Aggregation: tt.agg,
+			}
+			if err := view.Register(v); err != nil {
+				t.Fatal(err)
+			}
+			defer view.Unregister(v)
+			tt.records(context.Background(), m)
+
+			rec := httptest.NewRecorder()
+			metricsHandler([]*view.View{v}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("metrics =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
@@ -9,11 +9,13 @@ import (
 	"image/png"
 	"os"
 	"path/filepath"
+	"unicode/utf8"
 
 	"giautm.dev/captcha/binimg"
 	"giautm.dev/captcha/engine"
-	"giautm.dev/captcha/fisheye"
 	"giautm.dev/captcha/labeled"
+	"giautm.dev/captcha/preprocess"
+	"giautm.dev/captcha/segment"
 	"github.com/gammazero/workerpool"
 )
 
@@ -22,21 +24,31 @@ var (
 	outputDir    = flag.String("output", "./labeled", "Output directory with labeled images")
 	outputFormat = flag.String("outputFormat", "png", "Format of output images: png/jpeg")
 	workers      = flag.Int("workers", 100, "Number of workers")
-	processor    = flag.String("processor", "", "Pre-processor for images")
+	encoder      = flag.String("encoder", binimg.DefaultEncoderSpec, binimg.EncoderUsage)
+	segmented    = flag.Bool("segment", false, "Save the glyphs cropped by segmentation, for knn-index, instead of position encoded images")
+	maxLen       = flag.Int("maxLen", labeled.DefaultPositions, "Maximum length of captchas, the number of encoded positions")
+	blank        = flag.String("blank", labeled.DefaultBlank, "Label of the positions past the end of shorter captchas")
+	processor    = flag.String("processor", "", "Pre-processing pipeline for images, like fisheye,grayscale,otsu")
 )
 
 func main() {
 	flag.Parse()
-	if *dir == "" || *outputDir == "" || *workers < 1 {
+	if *dir == "" || *outputDir == "" || *workers < 1 || *maxLen < 1 {
 		flag.Usage()
 		return
 	}
-	var p engine.Preprocessor = &noopProcess{}
-	if *processor == "fisheye" {
-		p = fisheye.NewPreprocessor()
+	p, err := preprocess.Parse(*processor)
+	if err != nil {
+		fmt.Printf("error parsing the processor %q: %v\n", *processor, err)
+		return
+	}
+	enc, err := binimg.ParseEncoder(*encoder)
+	if err != nil {
+		fmt.Printf("error parsing the encoder %q: %v\n", *encoder, err)
+		return
 	}
 	wp := workerpool.New(*workers)
-	err := filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			fmt.Printf("prevent panic by handling failure accessing a path %q: %v\n", path, err)
 			return err
@@ -46,7 +58,9 @@ func main() {
 		}
 		wp.Submit(func() {
 			defer fmt.Printf("processed file: %q\n", path)
-			processFile(p, path, info.Name())
+			if err := processFile(p, enc, path, info.Name()); err != nil {
+				fmt.Printf("error processing the file %q: %v\n", path, err)
+			}
 		})
 		return nil
 	})
@@ -57,13 +71,11 @@ func main() {
 	}
 }
 
-type noopProcess struct{}
-
-func (*noopProcess) Transform(_ context.Context, img image.Image) (image.Image, error) {
-	return img, nil
-}
-
-func processFile(p engine.Preprocessor, path, name string) error {
+func processFile(p engine.Preprocessor, enc binimg.PositionEncoder, path, name string) error {
+	captcha := labeled.CaptchaFromName(name)
+	if n := utf8.RuneCountInString(captcha); n > *maxLen {
+		return fmt.Errorf("captcha %q is longer than the %d encoded positions", captcha, *maxLen)
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -80,10 +92,12 @@ func processFile(p engine.Preprocessor, path, name string) error {
 		return err
 	}
 
-	captcha := labeled.CaptchaFromName(name)
-	imgs := binimg.GenImages(result, len(captcha), 10)
+	if *segmented {
+		return saveGlyphs(context.Background(), result, captcha, name)
+	}
+	imgs := binimg.Encode(result, *maxLen, enc)
 	for idx, bimg := range imgs {
-		err = saveImage(bimg, string(captcha[idx]), name)
+		err = saveImage(bimg, labeled.SymbolAt(captcha, idx, *blank), name)
 		if err != nil {
 			return err
 		}
@@ -91,6 +105,26 @@ func processFile(p engine.Preprocessor, path, name string) error {
 	return nil
 }
 
+// saveGlyphs saves a glyph per character of the captcha, cropped by
+// segmentation. The position prefixes the name, as a character can
+// be repeated.
+func saveGlyphs(ctx context.Context, img image.Image, captcha, name string) error {
+	chars := []rune(captcha)
+	if len(chars) == 0 {
+		return fmt.Errorf("no captcha in the name %q", name)
+	}
+	glyphs, err := segment.NewSplitter(len(chars)).Split(ctx, img)
+	if err != nil {
+		return err
+	}
+	for i, g := range glyphs {
+		if err := saveImage(g, string(chars[i]), fmt.Sprintf("%d-%s", i, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func saveImage(img image.Image, label, name string) error {
 	f, err := labeled.CreateLabeledFile(*outputDir, label, name)
 	if err != nil {
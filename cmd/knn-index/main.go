@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"giautm.dev/captcha/knnsymbol"
+)
+
+var (
+	dir    = flag.String("dir", "./labeled", "Input directory with labeled glyphs, written by label-imgs -segment")
+	output = flag.String("output", "./knn.index", "Output index file")
+	width  = flag.Int("width", 16, "Width of the downsampled features")
+	height = flag.Int("height", 16, "Height of the downsampled features")
+)
+
+func main() {
+	flag.Parse()
+	if *dir == "" || *output == "" {
+		flag.Usage()
+		return
+	}
+	ix, err := knnsymbol.BuildIndex(*dir, knnsymbol.WithFeatureSize(*width, *height))
+	if err != nil {
+		fmt.Printf("error building the index from %q: %v\n", *dir, err)
+		return
+	}
+	if err = ix.SaveFile(*output); err != nil {
+		fmt.Printf("error saving the index to %q: %v\n", *output, err)
+		return
+	}
+	fmt.Printf("indexed %d images of %d labels\n", len(ix.Samples), len(ix.Labels))
+}
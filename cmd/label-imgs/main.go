@@ -1,17 +1,19 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
 	"fmt"
 	"image"
 	"image/png"
 	"os"
 	"path/filepath"
+	"unicode/utf8"
 
 	"giautm.dev/captcha/binimg"
 	"giautm.dev/captcha/fisheye"
 	"giautm.dev/captcha/labeled"
+	"giautm.dev/captcha/segment"
 	"github.com/gammazero/workerpool"
 )
 
@@ -19,21 +21,26 @@ var (
 	dir       = flag.String("dir", "./labeled", "Input directory with labeled images")
 	outputDir = flag.String("output", "./labeled", "Output directory with labeled images")
 	workers   = flag.Int("workers", 100, "Number of workers")
-)
-
-const (
-	binWidth     = 10
-	testRowIndex = 42
+	maxLen    = flag.Int("maxLen", labeled.DefaultPositions, "Maximum length of captchas, the number of encoded positions")
+	blank     = flag.String("blank", labeled.DefaultBlank, "Label of the positions past the end of shorter captchas")
+	encoder   = flag.String("encoder", binimg.DefaultEncoderSpec, binimg.EncoderUsage)
+	segmented = flag.Bool("segment", false, "Save the glyphs cropped by segmentation, for knn-index, instead of position encoded images")
+	testRow   = flag.Int("testRow", fisheye.AutoTestRow, "Row scored to find the fisheye distance, -1 to select it from the image")
 )
 
 func main() {
 	flag.Parse()
-	if *dir == "" || *outputDir == "" || *workers < 1 {
+	if *dir == "" || *outputDir == "" || *workers < 1 || *maxLen < 1 {
 		flag.Usage()
 		return
 	}
+	enc, err := binimg.ParseEncoder(*encoder)
+	if err != nil {
+		fmt.Printf("error parsing the encoder %q: %v\n", *encoder, err)
+		return
+	}
 	wp := workerpool.New(*workers)
-	err := filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			fmt.Printf("prevent panic by handling failure accessing a path %q: %v\n", path, err)
 			return err
@@ -43,7 +50,9 @@ func main() {
 		}
 		wp.Submit(func() {
 			defer fmt.Printf("processed file: %q\n", path)
-			processFile(path, info.Name())
+			if err := processFile(enc, path, info.Name()); err != nil {
+				fmt.Printf("error processing the file %q: %v\n", path, err)
+			}
 		})
 		return nil
 	})
@@ -54,7 +63,11 @@ func main() {
 	}
 }
 
-func processFile(path, name string) error {
+func processFile(enc binimg.PositionEncoder, path, name string) error {
+	captcha := labeled.CaptchaFromName(name)
+	if n := utf8.RuneCountInString(captcha); n > *maxLen {
+		return fmt.Errorf("captcha %q is longer than the %d encoded positions", captcha, *maxLen)
+	}
 	file, err := os.Open(path)
 	if err != nil {
 		return err
@@ -65,14 +78,16 @@ func processFile(path, name string) error {
 	if err != nil {
 		return err
 	}
-	result, distance := fisheye.FindDistance(img, testRowIndex)
-	if distance < 0 {
-		return errors.New("can not detect distance")
+	result, err := newPreprocessor().Transform(context.Background(), img)
+	if err != nil {
+		return err
+	}
+	if *segmented {
+		return saveGlyphs(context.Background(), result, captcha, name)
 	}
-	captcha := labeled.CaptchaFromName(name)
-	images := binimg.GenImages(result, len(captcha), binWidth)
+	images := binimg.Encode(result, *maxLen, enc)
 	for idx, bimg := range images {
-		err = saveImage(bimg, string(captcha[idx]), name)
+		err = saveImage(bimg, labeled.SymbolAt(captcha, idx, *blank), name)
 		if err != nil {
 			return err
 		}
@@ -80,6 +95,34 @@ func processFile(path, name string) error {
 	return nil
 }
 
+func newPreprocessor() *fisheye.FisheyePreprocessor {
+	p := fisheye.NewPreprocessor()
+	if *testRow != fisheye.AutoTestRow {
+		p.TestRowIndex = *testRow
+	}
+	return p
+}
+
+// saveGlyphs saves a glyph per character of the captcha, cropped by
+// segmentation. The position prefixes the name, as a character can
+// be repeated.
+func saveGlyphs(ctx context.Context, img image.Image, captcha, name string) error {
+	chars := []rune(captcha)
+	if len(chars) == 0 {
+		return fmt.Errorf("no captcha in the name %q", name)
+	}
+	glyphs, err := segment.NewSplitter(len(chars)).Split(ctx, img)
+	if err != nil {
+		return err
+	}
+	for i, g := range glyphs {
+		if err := saveImage(g, string(chars[i]), fmt.Sprintf("%d-%s", i, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func saveImage(img image.Image, label, name string) error {
 	f, err := labeled.CreateLabeledFile(*outputDir, label, name)
 	if err != nil {
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"flag"
 	"fmt"
 	"image"
@@ -21,34 +21,41 @@ var (
 	dir       = flag.String("dir", "./data/incorrect", "Input directory with labeled images")
 	doneDir   = flag.String("done", "./data/done", "Input directory with labeled images")
 	outputDir = flag.String("output", "./data/labeled", "Output directory with labeled images")
-)
-
-const (
-	captchaLen   = 5
-	binWidth     = 10
-	testRowIndex = 42
+	encoder   = flag.String("encoder", binimg.DefaultEncoderSpec, binimg.EncoderUsage)
+	testRow   = flag.Int("testRow", fisheye.AutoTestRow, "Row scored to find the fisheye distance, -1 to select it from the image")
+	minLen    = flag.Int("minLen", labeled.DefaultPositions, "Minimum length of captchas")
+	maxLen    = flag.Int("maxLen", labeled.DefaultPositions, "Maximum length of captchas, the number of encoded positions")
+	blank     = flag.String("blank", labeled.DefaultBlank, "Label of the positions past the end of shorter captchas")
 )
 
 func main() {
 	flag.Parse()
-	if *dir == "" || *outputDir == "" {
+	if *dir == "" || *outputDir == "" || *minLen < 1 || *maxLen < *minLen {
 		flag.Usage()
 		return
 	}
+	enc, err := binimg.ParseEncoder(*encoder)
+	if err != nil {
+		fmt.Printf("error parsing the encoder %q: %v\n", *encoder, err)
+		return
+	}
 	prompt := promptui.Prompt{
 		Label: "What's the correct captcha?",
 		Validate: func(input string) error {
-			if len(input) != captchaLen {
-				return errors.New("Cần nhập đủ 5 ký tự")
+			if n := len(input); n >= *minLen && n <= *maxLen {
+				return nil
 			}
-			return nil
+			if *minLen == *maxLen {
+				return fmt.Errorf("Cần nhập đủ %d ký tự", *minLen)
+			}
+			return fmt.Errorf("Cần nhập từ %d đến %d ký tự", *minLen, *maxLen)
 		},
 	}
-	err := filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(*dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil || info.IsDir() {
 			return err
 		}
-		return relabelFile(path, info, prompt.Run)
+		return relabelFile(enc, path, info, prompt.Run)
 	})
 	if err != nil {
 		fmt.Printf("error walking the path %q: %v\n", *dir, err)
@@ -56,7 +63,7 @@ func main() {
 	}
 }
 
-func relabelFile(path string, info os.FileInfo, askCaptcha func() (string, error)) error {
+func relabelFile(enc binimg.PositionEncoder, path string, info os.FileInfo, askCaptcha func() (string, error)) error {
 	fmt.Printf("-> Process: %s\n", path)
 	file, err := os.OpenFile(path, os.O_RDONLY, 0)
 	if err != nil {
@@ -80,13 +87,13 @@ func relabelFile(path string, info os.FileInfo, askCaptcha func() (string, error
 	if err = os.Rename(path, filepath.Join(*doneDir, name)); err != nil {
 		return err
 	}
-	captcha := []rune(labeled.CaptchaFromName(info.Name()))
+	captcha := labeled.CaptchaFromName(info.Name())
 	wrongs := map[int]string{}
 	wrongChars := []string{}
-	for idx, c := range result {
-		if captcha[idx] != c {
-			wrongs[idx] = (string)(c)
-			wrongChars = append(wrongChars, wrongs[idx])
+	for idx := 0; idx < *maxLen; idx++ {
+		if label := labeled.SymbolAt(result, idx, *blank); labeled.SymbolAt(captcha, idx, *blank) != label {
+			wrongs[idx] = label
+			wrongChars = append(wrongChars, label)
 		}
 	}
 	if c := len(wrongs); c > 0 {
@@ -97,12 +104,16 @@ func relabelFile(path string, info os.FileInfo, askCaptcha func() (string, error
 		if err != nil {
 			return err
 		}
-		img, distance := fisheye.FindDistance(img, testRowIndex)
-		if distance < 0 {
-			return errors.New("preprocess: can not detect distance")
+		p := fisheye.NewPreprocessor()
+		if *testRow != fisheye.AutoTestRow {
+			p.TestRowIndex = *testRow
+		}
+		img, err = p.Transform(context.Background(), img)
+		if err != nil {
+			return err
 		}
 		for pos, label := range wrongs {
-			if err = genBinFile(img, pos, label, name); err != nil {
+			if err = genBinFile(enc, img, pos, label, name); err != nil {
 				return err
 			}
 		}
@@ -110,12 +121,12 @@ func relabelFile(path string, info os.FileInfo, askCaptcha func() (string, error
 	return nil
 }
 
-func genBinFile(img image.Image, pos int, label, name string) error {
+func genBinFile(enc binimg.PositionEncoder, img image.Image, pos int, label, name string) error {
 	file, err := labeled.CreateLabeledFile(*outputDir, label, name)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	bImg := binimg.MarkPosition(binimg.ExpandLeft(img, binWidth*captchaLen), binWidth, pos)
+	bImg := enc.Encode(img, *maxLen, pos)
 	return png.Encode(file, bImg)
 }
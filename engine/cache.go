@@ -0,0 +1,385 @@
+package engine
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// CachedResolver is a CaptchaResolver that caches the results of
+	// another one, by the content of the decoded captcha image.
+	//
+	// The results reported as incorrect are evicted from the cache.
+	CachedResolver struct {
+		resolver CaptchaResolver
+		memory   *lruCache
+		store    CacheStore
+	}
+	// CacheStore is a second-level store of the CachedResolver, shared
+	// between processes or kept across restarts. Get fails with
+	// ErrCacheMiss if the key is not stored.
+	CacheStore interface {
+		Get(ctx context.Context, key string) (*CaptchaResult, error)
+		Put(ctx context.Context, key string, result *CaptchaResult) error
+		Delete(ctx context.Context, key string) error
+	}
+	// CacheOption is a function that sets an option on the CachedResolver.
+	CacheOption func(*CachedResolver) error
+	// DiskStore is a CacheStore keeping a JSON file per result in Dir.
+	DiskStore struct {
+		Dir string
+		// TTL is the time a result is kept, 0 keeps it forever.
+		TTL time.Duration
+	}
+)
+
+var (
+	ErrCacheMiss = errors.New("engine: cache miss")
+	// ErrNoCacheKey is returned when the cache key of a reported
+	// result is missing or invalid.
+	ErrNoCacheKey = errors.New("engine: result has no valid cache key")
+)
+
+// WithCacheSize sets the number of results kept in memory.
+// The default is 1024.
+func WithCacheSize(n int) CacheOption {
+	return func(c *CachedResolver) error {
+		if n < 1 {
+			return errors.New("engine: cache size must be positive")
+		}
+		c.memory.size = n
+		return nil
+	}
+}
+
+// WithCacheTTL sets the time a result is kept in memory, 0 keeps it
+// until it is evicted by newer ones. The default is 10 minutes.
+func WithCacheTTL(d time.Duration) CacheOption {
+	return func(c *CachedResolver) error {
+		c.memory.ttl = d
+		return nil
+	}
+}
+
+// WithCacheStore adds a store looked up on memory misses.
+// The errors of the store are taken as misses.
+func WithCacheStore(s CacheStore) CacheOption {
+	return func(c *CachedResolver) error {
+		c.store = s
+		return nil
+	}
+}
+
+// NewCachedResolver creates a new CachedResolver over the resolver.
+func NewCachedResolver(r CaptchaResolver, opts ...CacheOption) (*CachedResolver, error) {
+	c := &CachedResolver{
+		resolver: r,
+		memory: &lruCache{
+			size:  1024,
+			ttl:   10 * time.Minute,
+			ll:    list.New(),
+			items: map[string]*list.Element{},
+		},
+	}
+	for _, fn := range opts {
+		if err := fn(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// ResolveFile resolves the captcha from the file.
+func (c *CachedResolver) ResolveFile(ctx context.Context, r io.Reader) (*CaptchaResult, error) {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(source))
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.ResolveImage(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	result.Source = source
+	return result, nil
+}
+
+// ResolveImage returns the cached result of the image,
+// or resolves and caches it.
+func (c *CachedResolver) ResolveImage(ctx context.Context, img image.Image) (*CaptchaResult, error) {
+	key := ImageKey(img)
+	if result, ok := c.get(ctx, key); ok {
+		return result, nil
+	}
+	result, err := c.resolver.ResolveImage(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	result.Key = key
+	c.memory.put(key, result.clone())
+	if c.store != nil {
+		c.store.Put(ctx, key, result)
+	}
+	return result, nil
+}
+
+// Report evicts the result if it is incorrect, then reports it to the
+// resolver if it is a ResultReporter. The result is found by its Key,
+// or by its Source. An incorrect result with neither fails with
+// ErrNoCacheKey, as its answer could be served again.
+func (c *CachedResolver) Report(ctx context.Context, result *CaptchaResult, correct bool) error {
+	var errs []error
+	if !correct {
+		key, err := reportKey(result)
+		if err != nil {
+			return err
+		}
+		c.memory.delete(key)
+		if c.store != nil {
+			errs = append(errs, c.store.Delete(ctx, key))
+		}
+	}
+	if r, ok := c.resolver.(ResultReporter); ok {
+		errs = append(errs, r.Report(ctx, result, correct))
+	}
+	return errors.Join(errs...)
+}
+
+// ValidateReport implements the ReportValidator interface, an incorrect
+// result needs its Key or its Source to be evicted.
+func (c *CachedResolver) ValidateReport(result *CaptchaResult, correct bool) error {
+	if correct {
+		return nil
+	}
+	_, err := reportKey(result)
+	return err
+}
+
+// reportKey returns the cache key of a reported result,
+// from its Key or else its Source.
+func reportKey(result *CaptchaResult) (string, error) {
+	key := result.Key
+	if key == "" && len(result.Source) > 0 {
+		img, _, err := image.Decode(bytes.NewReader(result.Source))
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrNoCacheKey, err)
+		}
+		key = ImageKey(img)
+	}
+	if !validKey(key) {
+		return "", ErrNoCacheKey
+	}
+	return key, nil
+}
+
+func (c *CachedResolver) get(ctx context.Context, key string) (*CaptchaResult, bool) {
+	if result, ok := c.memory.get(key); ok {
+		return result.clone(), true
+	}
+	if c.store == nil {
+		return nil, false
+	}
+	result, err := c.store.Get(ctx, key)
+	if err != nil {
+		return nil, false
+	}
+	result.Key = key
+	c.memory.put(key, result.clone())
+	return result, true
+}
+
+// clone returns a copy of the result, without its Source.
+func (r *CaptchaResult) clone() *CaptchaResult {
+	c := *r
+	c.Source = nil
+	c.Symbols = append([]SymbolResult(nil), r.Symbols...)
+	return &c
+}
+
+// ImageKey returns the hex encoded SHA-256 of the size and the pixels of
+// the image, the same image decoded from any format has the same key.
+func ImageKey(img image.Image) string {
+	h := sha256.New()
+	b := img.Bounds()
+	var buf [8]byte
+	binary.BigEndian.PutUint32(buf[:4], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(buf[4:], uint32(b.Dy()))
+	h.Write(buf[:])
+	if rgba, ok := img.(*image.RGBA); ok {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			i := rgba.PixOffset(b.Min.X, y)
+			h.Write(rgba.Pix[i : i+b.Dx()*4])
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	row := make([]byte, b.Dx()*4)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			i := (x - b.Min.X) * 4
+			row[i], row[i+1], row[i+2], row[i+3] = uint8(r>>8), uint8(g>>8), uint8(bl>>8), uint8(a>>8)
+		}
+		h.Write(row)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// validKey reports whether the key is an ImageKey, it is also
+// a safe file name.
+func validKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// lruCache is a bounded least recently used cache of results.
+type (
+	lruCache struct {
+		mu    sync.Mutex
+		size  int
+		ttl   time.Duration
+		ll    *list.List
+		items map[string]*list.Element
+	}
+	lruEntry struct {
+		key     string
+		result  *CaptchaResult
+		expires time.Time
+	}
+)
+
+func (c *lruCache) get(key string) (*CaptchaResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.result, true
+}
+
+func (c *lruCache) put(key string, result *CaptchaResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := &lruEntry{key: key, result: result}
+	if c.ttl > 0 {
+		e.expires = time.Now().Add(c.ttl)
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(e)
+	for c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+// Get implements the CacheStore interface.
+func (s *DiskStore) Get(_ context.Context, key string) (*CaptchaResult, error) {
+	if !validKey(key) {
+		return nil, ErrNoCacheKey
+	}
+	path := s.path(key)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+	if s.TTL > 0 && time.Since(info.ModTime()) > s.TTL {
+		os.Remove(path)
+		return nil, ErrCacheMiss
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var result CaptchaResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Put implements the CacheStore interface. The file is written
+// to a temporary one first, so readers never see it partially.
+func (s *DiskStore) Put(_ context.Context, key string, result *CaptchaResult) error {
+	if !validKey(key) {
+		return ErrNoCacheKey
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.path(key))
+}
+
+// Delete implements the CacheStore interface.
+func (s *DiskStore) Delete(_ context.Context, key string) error {
+	if !validKey(key) {
+		return ErrNoCacheKey
+	}
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *DiskStore) path(key string) string {
+	return filepath.Join(s.Dir, key+".json")
+}
@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// countingResolver resolves the image of width n to the captcha "n",
+// and counts its calls.
+type countingResolver struct {
+	calls int
+}
+
+func (r *countingResolver) ResolveFile(ctx context.Context, f io.Reader) (*CaptchaResult, error) {
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	return r.ResolveImage(ctx, img)
+}
+
+func (r *countingResolver) ResolveImage(_ context.Context, img image.Image) (*CaptchaResult, error) {
+	r.calls++
+	return &CaptchaResult{Captcha: strconv.Itoa(img.Bounds().Dx())}, nil
+}
+
+// sized returns a blank image of width n.
+func sized(n int) image.Image {
+	return image.NewGray(image.Rect(0, 0, n, 1))
+}
+
+func TestCachedResolverLRU(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		// widths are the images resolved in order.
+		widths    []int
+		wantCalls int
+	}{
+		{"hits", 2, []int{1, 2, 1, 2}, 2},
+		{"evicts oldest", 2, []int{1, 2, 3, 1}, 4},
+		{"keeps recent", 2, []int{1, 2, 1, 3, 1}, 3},
+		{"single", 1, []int{1, 2, 1}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &countingResolver{}
+			c, err := NewCachedResolver(r, WithCacheSize(tt.size), WithCacheTTL(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, w := range tt.widths {
+				result, err := c.ResolveImage(context.Background(), sized(w))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := strconv.Itoa(w); result.Captcha != want {
+					t.Errorf("captcha = %q, want %q", result.Captcha, want)
+				}
+			}
+			if r.calls != tt.wantCalls {
+				t.Errorf("resolved %d times, want %d", r.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCachedResolverTTL(t *testing.T) {
+	r := &countingResolver{}
+	c, err := NewCachedResolver(r, WithCacheTTL(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	c.ResolveImage(ctx, sized(1))
+	c.ResolveImage(ctx, sized(1))
+	if r.calls != 1 {
+		t.Fatalf("resolved %d times before the TTL, want 1", r.calls)
+	}
+	time.Sleep(40 * time.Millisecond)
+	c.ResolveImage(ctx, sized(1))
+	if r.calls != 2 {
+		t.Errorf("resolved %d times after the TTL, want 2", r.calls)
+	}
+}
+
+func TestCachedResolverReport(t *testing.T) {
+	var source bytes.Buffer
+	if err := png.Encode(&source, sized(3)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// reported returns the result given back to Report.
+		reported  func(t *testing.T, result *CaptchaResult) *CaptchaResult
+		correct   bool
+		wantErr   error
+		wantCalls int
+	}{
+		{
+			name:      "correct kept",
+			reported:  func(_ *testing.T, r *CaptchaResult) *CaptchaResult { return r },
+			correct:   true,
+			wantCalls: 1,
+		},
+		{
+			name:      "incorrect evicted",
+			reported:  func(_ *testing.T, r *CaptchaResult) *CaptchaResult { return r },
+			wantCalls: 2,
+		},
+		{
+			name: "evicted by key from json",
+			reported: func(t *testing.T, r *CaptchaResult) *CaptchaResult {
+				data, err := json.Marshal(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var decoded CaptchaResult
+				if err := json.Unmarshal(data, &decoded); err != nil {
+					t.Fatal(err)
+				}
+				return &decoded
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "evicted by source",
+			reported: func(_ *testing.T, r *CaptchaResult) *CaptchaResult {
+				return &CaptchaResult{Captcha: r.Captcha, Source: source.Bytes()}
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "no key",
+			reported: func(_ *testing.T, r *CaptchaResult) *CaptchaResult {
+				return &CaptchaResult{Captcha: r.Captcha}
+			},
+			wantErr:   ErrNoCacheKey,
+			wantCalls: 1,
+		},
+		{
+			name: "invalid key",
+			reported: func(_ *testing.T, r *CaptchaResult) *CaptchaResult {
+				return &CaptchaResult{Captcha: r.Captcha, Key: "../" + r.Key}
+			},
+			wantErr:   ErrNoCacheKey,
+			wantCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &countingResolver{}
+			c, err := NewCachedResolver(r, WithCacheStore(&DiskStore{Dir: t.TempDir()}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			result, err := c.ResolveFile(ctx, bytes.NewReader(source.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Report(ctx, tt.reported(t, result), tt.correct)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Report error = %v, want %v", err, tt.wantErr)
+			}
+			if _, err := c.ResolveImage(ctx, sized(3)); err != nil {
+				t.Fatal(err)
+			}
+			if r.calls != tt.wantCalls {
+				t.Errorf("resolved %d times, want %d", r.calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// SymbolFilter reports whether the symbol is allowed at a position.
+	SymbolFilter func(symbol string) bool
+	// ConstraintError is returned when no captcha allowed by the charsets
+	// and the pattern can be made of the resolved candidates.
+	ConstraintError struct {
+		// Captcha is the captcha as resolved, ignoring the constraints.
+		Captcha string
+	}
+	symbolFilterKey      struct{}
+	batchSymbolFilterKey struct{}
+)
+
+// maxCombinations bounds the number of candidate captchas tried
+// against the pattern.
+const maxCombinations = 1 << 14
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%v: resolved %q", ErrCaptchaConstraint, e.Captcha)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return ErrCaptchaConstraint
+}
+
+// Charset returns a SymbolFilter allowing the symbols made of
+// a single character of chars.
+func Charset(chars string) SymbolFilter {
+	return func(symbol string) bool {
+		r, n := utf8.DecodeRuneInString(symbol)
+		return n > 0 && n == len(symbol) && strings.ContainsRune(chars, r)
+	}
+}
+
+// WithSymbolFilter returns a context telling the SymbolResolver
+// the symbols allowed for the image.
+func WithSymbolFilter(ctx context.Context, f SymbolFilter) context.Context {
+	return context.WithValue(ctx, symbolFilterKey{}, f)
+}
+
+// SymbolFilterFromContext returns the SymbolFilter of the context, or nil
+// if every symbol is allowed.
+func SymbolFilterFromContext(ctx context.Context) SymbolFilter {
+	f, _ := ctx.Value(symbolFilterKey{}).(SymbolFilter)
+	return f
+}
+
+// WithBatchSymbolFilters is the batch variant of WithSymbolFilter,
+// with a SymbolFilter per image. A nil SymbolFilter allows every symbol.
+func WithBatchSymbolFilters(ctx context.Context, fs []SymbolFilter) context.Context {
+	return context.WithValue(ctx, batchSymbolFilterKey{}, fs)
+}
+
+// BatchSymbolFiltersFromContext returns the SymbolFilters of the context,
+// or nil if every symbol is allowed.
+func BatchSymbolFiltersFromContext(ctx context.Context) []SymbolFilter {
+	fs, _ := ctx.Value(batchSymbolFilterKey{}).([]SymbolFilter)
+	return fs
+}
+
+func (e *CaptchaResolveEngine) constrained() bool {
+	return e.charset != nil || len(e.charsets) > 0 || e.pattern != nil
+}
+
+// filterAt returns the SymbolFilter of the position, or nil.
+func (e *CaptchaResolveEngine) filterAt(i int) SymbolFilter {
+	var pos SymbolFilter
+	if i < len(e.charsets) {
+		pos = e.charsets[i]
+	}
+	switch {
+	case pos == nil:
+		return e.charset
+	case e.charset == nil:
+		return pos
+	}
+	return func(symbol string) bool {
+		return pos(symbol) && e.charset(symbol)
+	}
+}
+
+// symbolContext returns the context of the SymbolResolver for the position,
+// the blank label stays allowed to end the captcha.
+func (e *CaptchaResolveEngine) symbolContext(ctx context.Context, i int) context.Context {
+	f := e.filterAt(i)
+	if f == nil {
+		return ctx
+	}
+	return WithSymbolFilter(ctx, e.allowBlank(f))
+}
+
+// batchContext is the batch variant of symbolContext.
+func (e *CaptchaResolveEngine) batchContext(ctx context.Context, n int) context.Context {
+	if e.charset == nil && len(e.charsets) == 0 {
+		return ctx
+	}
+	fs := make([]SymbolFilter, n)
+	for i := range fs {
+		if f := e.filterAt(i); f != nil {
+			fs[i] = e.allowBlank(f)
+		}
+	}
+	return WithBatchSymbolFilters(ctx, fs)
+}
+
+func (e *CaptchaResolveEngine) allowBlank(f SymbolFilter) SymbolFilter {
+	if e.blank == "" {
+		return f
+	}
+	return func(symbol string) bool {
+		return symbol == e.blank || f(symbol)
+	}
+}
+
+// constrain returns the most probable symbols, out of the resolved ones
+// and their alternatives, allowed by the charsets and the pattern.
+func (e *CaptchaResolveEngine) constrain(symbols []SymbolResult) ([]SymbolResult, error) {
+	if !e.constrained() {
+		return symbols, nil
+	}
+	var captcha strings.Builder
+	candidates := make([][]SymbolCandidate, len(symbols))
+	for i, s := range symbols {
+		captcha.WriteString(s.Symbol)
+		f := e.filterAt(i)
+		for _, c := range s.candidates() {
+			if c.Symbol != e.blank && (f == nil || f(c.Symbol)) {
+				candidates[i] = append(candidates[i], c)
+			}
+		}
+	}
+	match := func(string) bool { return true }
+	if e.pattern != nil {
+		match = e.pattern.MatchString
+	}
+	picks, ok := bestCombination(candidates, match)
+	if !ok {
+		return nil, &ConstraintError{Captcha: captcha.String()}
+	}
+	for i, c := range picks {
+		s := &symbols[i]
+		if c.Symbol == s.Symbol {
+			continue
+		}
+		alternatives := make([]SymbolCandidate, 0, len(s.Alternatives))
+		for _, a := range s.candidates() {
+			if a.Symbol != c.Symbol {
+				alternatives = append(alternatives, a)
+			}
+		}
+		sort.SliceStable(alternatives, func(i, j int) bool {
+			return alternatives[i].Probability > alternatives[j].Probability
+		})
+		*s = SymbolResult{Symbol: c.Symbol, Probability: c.Probability, Alternatives: alternatives}
+	}
+	return symbols, nil
+}
+
+// candidates returns the symbol and its alternatives.
+func (s *SymbolResult) candidates() []SymbolCandidate {
+	return append([]SymbolCandidate{{Symbol: s.Symbol, Probability: s.Probability}}, s.Alternatives...)
+}
+
+// bestCombination returns a candidate per position, with the highest product
+// of probabilities, whose captcha matches. The candidates of a position must
+// be sorted from the most to the least probable.
+func bestCombination(candidates [][]SymbolCandidate, match func(string) bool) ([]SymbolCandidate, bool) {
+	logProb := func(pos, i int) float64 {
+		return math.Log(max(float64(candidates[pos][i].Probability), 1e-12))
+	}
+	start := &combination{picks: make([]int, len(candidates))}
+	for pos, cs := range candidates {
+		if len(cs) == 0 {
+			return nil, false
+		}
+		start.score += logProb(pos, 0)
+	}
+	// The combinations are enumerated from the most probable, each one
+	// once, by only moving the positions from the last moved one.
+	queue := &combinationQueue{start}
+	for n := 0; queue.Len() > 0 && n < maxCombinations; n++ {
+		c := heap.Pop(queue).(*combination)
+		var captcha strings.Builder
+		for pos, i := range c.picks {
+			captcha.WriteString(candidates[pos][i].Symbol)
+		}
+		if match(captcha.String()) {
+			picks := make([]SymbolCandidate, len(c.picks))
+			for pos, i := range c.picks {
+				picks[pos] = candidates[pos][i]
+			}
+			return picks, true
+		}
+		for pos := c.last; pos < len(c.picks); pos++ {
+			i := c.picks[pos]
+			if i+1 >= len(candidates[pos]) {
+				continue
+			}
+			next := &combination{
+				picks: append([]int(nil), c.picks...),
+				score: c.score - logProb(pos, i) + logProb(pos, i+1),
+				last:  pos,
+			}
+			next.picks[pos]++
+			heap.Push(queue, next)
+		}
+	}
+	return nil, false
+}
+
+type (
+	combination struct {
+		picks []int
+		score float64
+		last  int
+	}
+	combinationQueue []*combination
+)
+
+func (q combinationQueue) Len() int           { return len(q) }
+func (q combinationQueue) Less(i, j int) bool { return q[i].score > q[j].score }
+func (q combinationQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *combinationQueue) Push(x any)        { *q = append(*q, x.(*combination)) }
+func (q *combinationQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// positionCandidates parses the candidates of the positions, like
+// "a.6 b.4|c1" for two positions, the symbols are single characters.
+func positionCandidates(spec string) [][]SymbolCandidate {
+	var candidates [][]SymbolCandidate
+	for _, pos := range strings.Split(spec, "|") {
+		cs := []SymbolCandidate{}
+		for _, f := range strings.Fields(pos) {
+			p, err := strconv.ParseFloat(f[1:], 32)
+			if err != nil {
+				panic(err)
+			}
+			cs = append(cs, SymbolCandidate{Symbol: f[:1], Probability: float32(p)})
+		}
+		candidates = append(candidates, cs)
+	}
+	return candidates
+}
+
+func captchaOf(picks []SymbolCandidate) string {
+	var b strings.Builder
+	for _, c := range picks {
+		b.WriteString(c.Symbol)
+	}
+	return b.String()
+}
+
+func TestBestCombination(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates string
+		pattern    string
+		want       string
+		ok         bool
+	}{
+		{"best matches", "a.6 b.4|c.9 d.1", ".*", "ac", true},
+		{"second position", "a.6 b.4|c.9 d.1", "ad", "ad", true},
+		{"first position", "a.6 b.4|c.9 d.1", "bc", "bc", true},
+		{"most probable swap", "a.5 b.4|c.9 d.8", "b.|.d", "ad", true},
+		{"product over count", "a.9 b.1|c.5 d.45|e.5 f.45", "b..|.df", "adf", true},
+		{"digits", "o.7 0.3|l.6 1.4|2.9", "[0-9]+", "012", true},
+		{"no match", "a.6 b.4|c.9 d.1", "z.", "", false},
+		{"empty position", "a.6|", ".*", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re := regexp.MustCompile(`^(?:` + tt.pattern + `)$`)
+			picks, ok := bestCombination(positionCandidates(tt.candidates), re.MatchString)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if got := captchaOf(picks); got != tt.want {
+				t.Errorf("captcha = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestBestCombinationExhaustive compares bestCombination with the
+// product of every combination, on random candidates.
+func TestBestCombinationExhaustive(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	patterns := []*regexp.Regexp{
+		regexp.MustCompile(`^[0-9]+$`),
+		regexp.MustCompile(`^[a-z][0-9]`),
+		regexp.MustCompile(`(.)\w*[b-d]$`),
+	}
+	const symbols = "abcde01234"
+	for n := range 200 {
+		candidates := make([][]SymbolCandidate, 1+r.Intn(4))
+		for pos := range candidates {
+			perm := r.Perm(len(symbols))[:1+r.Intn(5)]
+			for _, i := range perm {
+				candidates[pos] = append(candidates[pos], SymbolCandidate{Symbol: symbols[i : i+1], Probability: r.Float32()})
+			}
+			cs := candidates[pos]
+			for i := 1; i < len(cs); i++ {
+				for j := i; j > 0 && cs[j].Probability > cs[j-1].Probability; j-- {
+					cs[j], cs[j-1] = cs[j-1], cs[j]
+				}
+			}
+		}
+		match := patterns[n%len(patterns)].MatchString
+		want, wantOK := math.Inf(-1), false
+		var walk func(pos int, captcha string, score float64)
+		walk = func(pos int, captcha string, score float64) {
+			if pos == len(candidates) {
+				if match(captcha) && score > want {
+					want, wantOK = score, true
+				}
+				return
+			}
+			for _, c := range candidates[pos] {
+				walk(pos+1, captcha+c.Symbol, score+math.Log(max(float64(c.Probability), 1e-12)))
+			}
+		}
+		walk(0, "", 0)
+		picks, ok := bestCombination(candidates, match)
+		if ok != wantOK {
+			t.Fatalf("case %d: ok = %v, want %v", n, ok, wantOK)
+		}
+		if !ok {
+			continue
+		}
+		got := 0.0
+		for _, c := range picks {
+			got += math.Log(max(float64(c.Probability), 1e-12))
+		}
+		if !match(captchaOf(picks)) || math.Abs(got-want) > 1e-9 {
+			t.Errorf("case %d: %q scores %v, want %v", n, captchaOf(picks), got, want)
+		}
+	}
+}
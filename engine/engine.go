@@ -1,23 +1,58 @@
 package engine
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"image"
 	"io"
+	"regexp"
+	"strconv"
+	"sync"
 
 	"giautm.dev/captcha/binimg"
 )
 
 type (
 	CaptchaResolveEngine struct {
-		binaryWidth  int
-		captchaLen   int
+		concurrency  int
+		minLen       int
+		maxLen       int
+		blank        string
+		charset      SymbolFilter
+		charsets     []SymbolFilter
+		pattern      *regexp.Regexp
 		preprocessor Preprocessor
+		splitter     Splitter
 		symResolver  SymbolResolver
+		lenResolver  SymbolResolver
 	}
 	CaptchaResult struct {
 		Captcha string `json:"captcha"`
+		// Length is the number of symbols of the captcha.
+		Length int `json:"length"`
+		// Symbols holds the per-position results, it is only set when
+		// the SymbolResolver is a ScoredSymbolResolver.
+		Symbols []SymbolResult `json:"symbols,omitempty"`
+		// Source is the encoded captcha image, it is only set when
+		// the captcha is resolved from a file.
+		Source []byte `json:"-"`
+		// Key is the cache key of the image, set by CachedResolver.
+		// It is given back with the result to Report.
+		Key string `json:"key,omitempty"`
+	}
+	// SymbolResult is the resolved symbol at a position of the captcha,
+	// with its probability and the runner-up candidates.
+	SymbolResult struct {
+		Symbol       string            `json:"symbol"`
+		Probability  float32           `json:"probability"`
+		Alternatives []SymbolCandidate `json:"alternatives,omitempty"`
+	}
+	// SymbolCandidate is a candidate symbol with its probability.
+	SymbolCandidate struct {
+		Symbol      string  `json:"symbol"`
+		Probability float32 `json:"probability"`
 	}
 	CaptchaResolver interface {
 		ResolveFile(ctx context.Context, r io.Reader) (*CaptchaResult, error)
@@ -26,16 +61,48 @@ type (
 	Preprocessor interface {
 		Transform(ctx context.Context, img image.Image) (image.Image, error)
 	}
+	// Splitter splits the captcha image into one image per symbol,
+	// in reading order.
+	Splitter interface {
+		Split(ctx context.Context, img image.Image) ([]image.Image, error)
+	}
 	SymbolResolver interface {
 		SymbolResolve(ctx context.Context, img image.Image) (string, error)
 	}
+	// ScoredSymbolResolver is a SymbolResolver that also reports the
+	// probability of the resolved symbol and its top-k alternatives.
+	ScoredSymbolResolver interface {
+		SymbolResolver
+		SymbolResolveScored(ctx context.Context, img image.Image) (*SymbolResult, error)
+	}
+	// BatchSymbolResolver is a SymbolResolver that can resolve the symbols
+	// of all positions in one call.
+	BatchSymbolResolver interface {
+		SymbolResolver
+		SymbolResolveBatch(ctx context.Context, imgs []image.Image) ([]string, error)
+	}
+	// ScoredBatchSymbolResolver is the batch variant of ScoredSymbolResolver.
+	ScoredBatchSymbolResolver interface {
+		ScoredSymbolResolver
+		BatchSymbolResolver
+		SymbolResolveScoredBatch(ctx context.Context, imgs []image.Image) ([]SymbolResult, error)
+	}
 	ResultReporter interface {
 		Report(ctx context.Context, result *CaptchaResult, correct bool) error
 	}
+	// ReportValidator is a ResultReporter which can tell, before any
+	// reporter is called, whether it would reject the report.
+	ReportValidator interface {
+		ValidateReport(result *CaptchaResult, correct bool) error
+	}
 )
 
 var (
 	ErrCaptchaInvalid = errors.New("captcha is invalid")
+	ErrBatchMismatch  = errors.New("engine: batch result length mismatch")
+	ErrCaptchaLength  = errors.New("engine: captcha length out of range")
+	// ErrCaptchaConstraint is the error wrapped by ConstraintError.
+	ErrCaptchaConstraint = errors.New("engine: no captcha allowed by the constraints")
 )
 
 // NewCaptchaResolveEngine creates a new captcha resolve engine.
@@ -43,27 +110,61 @@ func NewCaptchaResolveEngine(opts ...Option) (*CaptchaResolveEngine, error) {
 	opt := &EngineOption{
 		binaryWidth: 10,
 		captchaLen:  5,
+		concurrency: 1,
 	}
 	for _, fn := range opts {
 		if err := fn(opt); err != nil {
 			return nil, err
 		}
 	}
+	if opt.encoder == nil {
+		opt.encoder = binimg.LeftBar{Width: opt.binaryWidth}
+	}
+	if opt.splitter == nil {
+		opt.splitter = &PositionSplitter{Count: opt.captchaLen, Encoder: opt.encoder}
+	}
+	if opt.stats {
+		if opt.preprocessor != nil {
+			opt.preprocessor = &StatsPreprocessor{opt.preprocessor}
+		}
+		if opt.symbol != nil {
+			opt.symbol = NewStatsSymbolResolver(opt.symbol)
+		}
+		if opt.lenResolver != nil {
+			opt.lenResolver = NewStatsSymbolResolver(opt.lenResolver)
+		}
+	}
 	return &CaptchaResolveEngine{
-		binaryWidth:  opt.binaryWidth,
-		captchaLen:   opt.captchaLen,
+		concurrency:  opt.concurrency,
+		minLen:       opt.minLen,
+		maxLen:       opt.maxLen,
+		blank:        opt.blank,
+		charset:      opt.charset,
+		charsets:     opt.charsets,
+		pattern:      opt.pattern,
 		preprocessor: opt.preprocessor,
+		splitter:     opt.splitter,
 		symResolver:  opt.symbol,
+		lenResolver:  opt.lenResolver,
 	}, nil
 }
 
 // ResolveFile resolves the captcha from the file.
 func (e *CaptchaResolveEngine) ResolveFile(ctx context.Context, r io.Reader) (*CaptchaResult, error) {
-	img, _, err := image.Decode(r)
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(source))
 	if err != nil {
 		return nil, err
 	}
-	return e.ResolveImage(ctx, img)
+	result, err := e.ResolveImage(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	result.Source = source
+	return result, nil
 }
 
 // ResolveImage resolves the captcha from the image.
@@ -74,14 +175,192 @@ func (e *CaptchaResolveEngine) ResolveImage(ctx context.Context, img image.Image
 			return nil, err
 		}
 	}
-	captcha := ""
-	binImages := binimg.GenImages(img, e.captchaLen, e.binaryWidth)
-	for _, bimg := range binImages {
-		symbol, err := e.symResolver.SymbolResolve(ctx, bimg)
+	images, err := e.splitter.Split(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	if e.lenResolver != nil {
+		n, err := e.resolveLength(ctx, img)
 		if err != nil {
 			return nil, err
 		}
-		captcha += symbol
+		if n < 0 || n > len(images) {
+			return nil, fmt.Errorf("%w: resolved length %d", ErrCaptchaLength, n)
+		}
+		images = images[:n]
+	}
+	symbols, err := e.resolveSymbols(ctx, images)
+	if err != nil {
+		return nil, err
+	}
+	if i := e.blankIndex(symbols); i >= 0 {
+		symbols = symbols[:i]
+	}
+	if e.maxLen > 0 && (len(symbols) < e.minLen || len(symbols) > e.maxLen) {
+		return nil, fmt.Errorf("%w: got %d, want %d to %d",
+			ErrCaptchaLength, len(symbols), e.minLen, e.maxLen)
+	}
+	if symbols, err = e.constrain(symbols); err != nil {
+		return nil, err
+	}
+	result := &CaptchaResult{Length: len(symbols)}
+	for _, s := range symbols {
+		result.Captcha += s.Symbol
+	}
+	if _, ok := e.symResolver.(ScoredSymbolResolver); ok {
+		result.Symbols = symbols
+	}
+	return result, nil
+}
+
+// resolveSymbols resolves the symbols of the images in one call if the
+// SymbolResolver supports batching, otherwise at most e.concurrency at a time.
+// The first error cancels the remaining ones.
+func (e *CaptchaResolveEngine) resolveSymbols(ctx context.Context, images []image.Image) ([]SymbolResult, error) {
+	switch sr := e.symResolver.(type) {
+	case ScoredBatchSymbolResolver:
+		symbols, err := sr.SymbolResolveScoredBatch(e.batchContext(ctx, len(images)), images)
+		if err != nil {
+			return nil, err
+		}
+		if len(symbols) != len(images) {
+			return nil, ErrBatchMismatch
+		}
+		return symbols, nil
+	case ScoredSymbolResolver:
+		// Prefer the scored results over batching.
+	case BatchSymbolResolver:
+		batch, err := sr.SymbolResolveBatch(e.batchContext(ctx, len(images)), images)
+		if err != nil {
+			return nil, err
+		}
+		if len(batch) != len(images) {
+			return nil, ErrBatchMismatch
+		}
+		symbols := make([]SymbolResult, len(batch))
+		for i, s := range batch {
+			symbols[i] = SymbolResult{Symbol: s}
+		}
+		return symbols, nil
+	}
+	symbols := make([]SymbolResult, len(images))
+	if e.concurrency <= 1 {
+		for i, img := range images {
+			s, err := e.resolveSymbol(e.symbolContext(ctx, i), img)
+			if err != nil {
+				return nil, err
+			}
+			symbols[i] = *s
+			if e.blank != "" && s.Symbol == e.blank {
+				// No need to resolve the positions past the end.
+				return symbols[:i+1], nil
+			}
+		}
+		return symbols, nil
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		sem      = make(chan struct{}, e.concurrency)
+	)
+	for i, img := range images {
+		select {
+		case sem <- struct{}{}:
+		case <-cctx.Done():
+		}
+		if cctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			s, err := e.resolveSymbol(e.symbolContext(cctx, i), img)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			symbols[i] = *s
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return symbols, nil
+}
+
+// resolveLength resolves the length of the captcha from the whole image.
+func (e *CaptchaResolveEngine) resolveLength(ctx context.Context, img image.Image) (int, error) {
+	symbol, err := e.lenResolver.SymbolResolve(ctx, img)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(symbol)
+	if err != nil {
+		return 0, fmt.Errorf("%w: resolved length %q", ErrCaptchaLength, symbol)
+	}
+	return n, nil
+}
+
+// blankIndex returns the index of the first blank symbol, or -1.
+func (e *CaptchaResolveEngine) blankIndex(symbols []SymbolResult) int {
+	if e.blank == "" {
+		return -1
+	}
+	for i, s := range symbols {
+		if s.Symbol == e.blank {
+			return i
+		}
+	}
+	return -1
+}
+
+func (e *CaptchaResolveEngine) resolveSymbol(ctx context.Context, img image.Image) (*SymbolResult, error) {
+	if sr, ok := e.symResolver.(ScoredSymbolResolver); ok {
+		return sr.SymbolResolveScored(ctx, img)
+	}
+	symbol, err := e.symResolver.SymbolResolve(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	return &SymbolResult{Symbol: symbol}, nil
+}
+
+// PositionSplitter is the default Splitter, it gives the whole image for
+// every position, with the position marked by the Encoder.
+type PositionSplitter struct {
+	// Count is the number of positions.
+	Count int
+	// Encoder marks the position on the image.
+	Encoder binimg.PositionEncoder
+}
+
+// Split implements the Splitter interface.
+func (s *PositionSplitter) Split(_ context.Context, img image.Image) ([]image.Image, error) {
+	return binimg.Encode(img, s.Count, s.Encoder), nil
+}
+
+// Confidence returns the lowest symbol probability of the captcha.
+// It returns false if the result has no per-symbol probabilities.
+func (r *CaptchaResult) Confidence() (float32, bool) {
+	if len(r.Symbols) == 0 {
+		return 0, false
+	}
+	c := r.Symbols[0].Probability
+	for _, s := range r.Symbols[1:] {
+		c = min(c, s.Probability)
 	}
-	return &CaptchaResult{Captcha: captcha}, nil
+	return c, true
 }
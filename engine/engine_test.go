@@ -0,0 +1,136 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// indexResolver resolves the image of width i+1 to the i-th letter,
+// the later positions faster, so that they complete out of order.
+// The position fail fails at once, the others wait for their context
+// to be canceled if block is set.
+type indexResolver struct {
+	fail    int
+	block   bool
+	started atomic.Int32
+}
+
+var errSymbol = errors.New("symbol failed")
+
+func (r *indexResolver) SymbolResolve(ctx context.Context, img image.Image) (string, error) {
+	r.started.Add(1)
+	i := img.Bounds().Dx() - 1
+	if i == r.fail {
+		return "", errSymbol
+	}
+	if r.block {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	if err := sleep(ctx, time.Duration(8-i)*time.Millisecond); err != nil {
+		return "", err
+	}
+	return string(rune('a' + i)), nil
+}
+
+// positions returns n images, the image of position i is i+1 wide.
+func positions(n int) []image.Image {
+	images := make([]image.Image, n)
+	for i := range images {
+		images[i] = image.NewGray(image.Rect(0, 0, i+1, 1))
+	}
+	return images
+}
+
+func TestResolveSymbolsOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+	}{
+		{"sequential", 1},
+		{"partial", 3},
+		{"all", 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewCaptchaResolveEngine(
+				WithSymbolResolver(&indexResolver{fail: -1}),
+				WithConcurrency(tt.concurrency),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			symbols, err := e.resolveSymbols(context.Background(), positions(6))
+			if err != nil {
+				t.Fatalf("resolveSymbols: %v", err)
+			}
+			got := ""
+			for _, s := range symbols {
+				got += s.Symbol
+			}
+			if got != "abcdef" {
+				t.Errorf("symbols = %q, want %q", got, "abcdef")
+			}
+		})
+	}
+}
+
+func TestResolveSymbolsFirstError(t *testing.T) {
+	tests := []struct {
+		name        string
+		concurrency int
+		fail        int
+		wantStarted int32
+	}{
+		{"sequential", 1, 1, 2},
+		{"partial", 3, 1, 3},
+		{"all", 8, 5, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &indexResolver{fail: tt.fail, block: tt.concurrency > 1}
+			e, err := NewCaptchaResolveEngine(
+				WithSymbolResolver(r),
+				WithConcurrency(tt.concurrency),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error, 1)
+			go func() {
+				_, err := e.resolveSymbols(context.Background(), positions(6))
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if !errors.Is(err, errSymbol) {
+					t.Errorf("resolveSymbols error = %v, want %v", err, errSymbol)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("resolveSymbols did not cancel the other positions")
+			}
+			if got := r.started.Load(); got != tt.wantStarted {
+				t.Errorf("started %d positions, want %d", got, tt.wantStarted)
+			}
+		})
+	}
+}
+
+func TestResolveSymbolsCanceled(t *testing.T) {
+	e, err := NewCaptchaResolveEngine(
+		WithSymbolResolver(&indexResolver{fail: -1, block: true}),
+		WithConcurrency(3),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := e.resolveSymbols(ctx, positions(6)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("resolveSymbols error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
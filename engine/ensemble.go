@@ -0,0 +1,231 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// EnsembleResolver is a SymbolResolver that combines several
+	// SymbolResolvers with an EnsembleStrategy.
+	EnsembleResolver struct {
+		resolvers []SymbolResolver
+		strategy  EnsembleStrategy
+		timeout   time.Duration
+	}
+	// EnsembleStrategy is the way an EnsembleResolver combines the symbols.
+	EnsembleStrategy int
+	// EnsembleOption is a function that sets an option on the EnsembleResolver.
+	EnsembleOption func(*EnsembleResolver) error
+)
+
+const (
+	// StrategyFallback returns the symbol of the first resolver that succeeds.
+	StrategyFallback EnsembleStrategy = iota
+	// StrategyMajority returns the symbol most resolvers agree on,
+	// ties are broken by the summed probability, then by resolver order.
+	StrategyMajority
+	// StrategyAverage returns the symbol with the highest probability
+	// averaged over the resolvers.
+	StrategyAverage
+)
+
+var (
+	ErrNoResolvers = errors.New("engine: ensemble needs at least one resolver")
+)
+
+// WithStrategy sets the strategy of the ensemble. The default is StrategyFallback.
+func WithStrategy(s EnsembleStrategy) EnsembleOption {
+	return func(e *EnsembleResolver) error {
+		if s < StrategyFallback || s > StrategyAverage {
+			return errors.New("engine: unknown ensemble strategy")
+		}
+		e.strategy = s
+		return nil
+	}
+}
+
+// WithResolverTimeout sets the timeout of each resolver call,
+// a resolver timing out is treated as failed. The default is no timeout.
+func WithResolverTimeout(d time.Duration) EnsembleOption {
+	return func(e *EnsembleResolver) error {
+		e.timeout = d
+		return nil
+	}
+}
+
+// NewEnsembleResolver creates a new EnsembleResolver over the resolvers.
+//
+// Resolvers which are not ScoredSymbolResolver are taken as certain of
+// their symbol, that is a probability of 1.
+func NewEnsembleResolver(resolvers []SymbolResolver, opts ...EnsembleOption) (*EnsembleResolver, error) {
+	if len(resolvers) == 0 {
+		return nil, ErrNoResolvers
+	}
+	e := &EnsembleResolver{resolvers: resolvers, strategy: StrategyFallback}
+	for _, fn := range opts {
+		if err := fn(e); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// SymbolResolve implements the SymbolResolver interface.
+func (e *EnsembleResolver) SymbolResolve(ctx context.Context, img image.Image) (string, error) {
+	result, err := e.SymbolResolveScored(ctx, img)
+	if err != nil {
+		return "", err
+	}
+	return result.Symbol, nil
+}
+
+// SymbolResolveScored implements the ScoredSymbolResolver interface.
+func (e *EnsembleResolver) SymbolResolveScored(ctx context.Context, img image.Image) (*SymbolResult, error) {
+	if e.strategy == StrategyFallback {
+		return e.fallback(ctx, img)
+	}
+	results, err := e.resolveAll(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	if e.strategy == StrategyMajority {
+		return majority(results), nil
+	}
+	return average(results), nil
+}
+
+func (e *EnsembleResolver) fallback(ctx context.Context, img image.Image) (*SymbolResult, error) {
+	var errs []error
+	for _, sr := range e.resolvers {
+		result, err := e.resolve(ctx, sr, img)
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// resolveAll calls all resolvers concurrently, it fails only
+// if none of them succeeds. Failed resolvers have a nil result.
+func (e *EnsembleResolver) resolveAll(ctx context.Context, img image.Image) ([]*SymbolResult, error) {
+	var (
+		wg      sync.WaitGroup
+		results = make([]*SymbolResult, len(e.resolvers))
+		errs    = make([]error, len(e.resolvers))
+	)
+	for i, sr := range e.resolvers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = e.resolve(ctx, sr, img)
+		}()
+	}
+	wg.Wait()
+	for _, r := range results {
+		if r != nil {
+			return results, nil
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (e *EnsembleResolver) resolve(ctx context.Context, sr SymbolResolver, img image.Image) (*SymbolResult, error) {
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	if s, ok := sr.(ScoredSymbolResolver); ok {
+		return s.SymbolResolveScored(ctx, img)
+	}
+	symbol, err := sr.SymbolResolve(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	return &SymbolResult{Symbol: symbol, Probability: 1}, nil
+}
+
+func majority(results []*SymbolResult) *SymbolResult {
+	type tally struct {
+		votes int
+		prob  float32
+		order int
+	}
+	tallies, n := map[string]*tally{}, 0
+	for i, r := range results {
+		if r == nil {
+			continue
+		}
+		n++
+		t, ok := tallies[r.Symbol]
+		if !ok {
+			t = &tally{order: i}
+			tallies[r.Symbol] = t
+		}
+		t.votes++
+		t.prob += r.Probability
+	}
+	candidates := make([]SymbolCandidate, 0, len(tallies))
+	for s, t := range tallies {
+		candidates = append(candidates, SymbolCandidate{
+			Symbol:      s,
+			Probability: float32(t.votes) / float32(n),
+		})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := tallies[candidates[i].Symbol], tallies[candidates[j].Symbol]
+		if a.votes != b.votes {
+			return a.votes > b.votes
+		}
+		if a.prob != b.prob {
+			return a.prob > b.prob
+		}
+		return a.order < b.order
+	})
+	return toSymbolResult(candidates)
+}
+
+func average(results []*SymbolResult) *SymbolResult {
+	sums, order, n := map[string]float32{}, []string{}, 0
+	add := func(s string, p float32) {
+		if _, ok := sums[s]; !ok {
+			order = append(order, s)
+		}
+		sums[s] += p
+	}
+	for _, r := range results {
+		if r == nil {
+			continue
+		}
+		n++
+		add(r.Symbol, r.Probability)
+		for _, a := range r.Alternatives {
+			add(a.Symbol, a.Probability)
+		}
+	}
+	candidates := make([]SymbolCandidate, len(order))
+	for i, s := range order {
+		candidates[i] = SymbolCandidate{Symbol: s, Probability: sums[s] / float32(n)}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Probability > candidates[j].Probability
+	})
+	return toSymbolResult(candidates)
+}
+
+func toSymbolResult(candidates []SymbolCandidate) *SymbolResult {
+	return &SymbolResult{
+		Symbol:       candidates[0].Symbol,
+		Probability:  candidates[0].Probability,
+		Alternatives: candidates[1:],
+	}
+}
@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"image"
+	"math"
+	"testing"
+	"time"
+)
+
+// stubResolver resolves every image to the symbol, after the delay.
+type stubResolver struct {
+	symbol string
+	p      float32
+	alts   []SymbolCandidate
+	err    error
+	delay  time.Duration
+}
+
+func (r stubResolver) SymbolResolve(ctx context.Context, img image.Image) (string, error) {
+	result, err := r.SymbolResolveScored(ctx, img)
+	if err != nil {
+		return "", err
+	}
+	return result.Symbol, nil
+}
+
+func (r stubResolver) SymbolResolveScored(ctx context.Context, _ image.Image) (*SymbolResult, error) {
+	if err := sleep(ctx, r.delay); err != nil {
+		return nil, err
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &SymbolResult{Symbol: r.symbol, Probability: r.p, Alternatives: r.alts}, nil
+}
+
+// unscoredResolver hides the ScoredSymbolResolver of the stub.
+type unscoredResolver struct {
+	stub stubResolver
+}
+
+func (r unscoredResolver) SymbolResolve(ctx context.Context, img image.Image) (string, error) {
+	return r.stub.SymbolResolve(ctx, img)
+}
+
+func TestEnsembleResolver(t *testing.T) {
+	failed := stubResolver{err: errSymbol}
+	tests := []struct {
+		name      string
+		strategy  EnsembleStrategy
+		timeout   time.Duration
+		resolvers []SymbolResolver
+		want      string
+		wantP     float32
+		wantErr   error
+	}{
+		{
+			name:      "fallback first success",
+			strategy:  StrategyFallback,
+			resolvers: []SymbolResolver{failed, stubResolver{symbol: "a", p: 0.6}, stubResolver{symbol: "b", p: 0.9}},
+			want:      "a", wantP: 0.6,
+		},
+		{
+			name:      "fallback all failed",
+			strategy:  StrategyFallback,
+			resolvers: []SymbolResolver{failed, failed},
+			wantErr:   errSymbol,
+		},
+		{
+			name:      "fallback timeout",
+			strategy:  StrategyFallback,
+			timeout:   5 * time.Millisecond,
+			resolvers: []SymbolResolver{stubResolver{symbol: "a", p: 0.9, delay: time.Second}, stubResolver{symbol: "b", p: 0.5}},
+			want:      "b", wantP: 0.5,
+		},
+		{
+			name:      "majority votes",
+			strategy:  StrategyMajority,
+			resolvers: []SymbolResolver{stubResolver{symbol: "a", p: 0.6}, stubResolver{symbol: "b", p: 0.9}, stubResolver{symbol: "a", p: 0.5}},
+			want:      "a", wantP: 2.0 / 3,
+		},
+		{
+			name:      "majority tie by probability",
+			strategy:  StrategyMajority,
+			resolvers: []SymbolResolver{stubResolver{symbol: "a", p: 0.6}, stubResolver{symbol: "b", p: 0.9}},
+			want:      "b", wantP: 0.5,
+		},
+		{
+			name:      "majority tie by order",
+			strategy:  StrategyMajority,
+			resolvers: []SymbolResolver{stubResolver{symbol: "b", p: 0.5}, stubResolver{symbol: "a", p: 0.5}},
+			want:      "b", wantP: 0.5,
+		},
+		{
+			name:      "majority skips failures",
+			strategy:  StrategyMajority,
+			resolvers: []SymbolResolver{failed, stubResolver{symbol: "a", p: 0.4}},
+			want:      "a", wantP: 1,
+		},
+		{
+			name:     "average alternatives",
+			strategy: StrategyAverage,
+			resolvers: []SymbolResolver{
+				stubResolver{symbol: "a", p: 0.6, alts: []SymbolCandidate{{Symbol: "b", Probability: 0.4}}},
+				stubResolver{symbol: "b", p: 0.9, alts: []SymbolCandidate{{Symbol: "a", Probability: 0.1}}},
+			},
+			want: "b", wantP: 0.65,
+		},
+		{
+			name:      "average unscored",
+			strategy:  StrategyAverage,
+			resolvers: []SymbolResolver{unscoredResolver{stubResolver{symbol: "a", p: 0.2}}, stubResolver{symbol: "b", p: 0.9}},
+			want:      "a", wantP: 0.5,
+		},
+		{
+			name:      "average all failed",
+			strategy:  StrategyAverage,
+			resolvers: []SymbolResolver{failed, failed},
+			wantErr:   errSymbol,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := NewEnsembleResolver(tt.resolvers, WithStrategy(tt.strategy), WithResolverTimeout(tt.timeout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := e.SymbolResolveScored(context.Background(), nil)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Symbol != tt.want || math.Abs(float64(got.Probability-tt.wantP)) > 1e-6 {
+				t.Errorf("result = %s %v, want %s %v", got.Symbol, got.Probability, tt.want, tt.wantP)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+	"go.opencensus.io/tag"
+)
+
+// The measures recorded by the Stats wrappers.
+var (
+	MeasureResolveLatency    = stats.Float64("captcha/engine/resolve_latency", "Latency of resolving a captcha", stats.UnitMilliseconds)
+	MeasurePreprocessLatency = stats.Float64("captcha/engine/preprocess_latency", "Latency of preprocessing a captcha", stats.UnitMilliseconds)
+	MeasureSymbolLatency     = stats.Float64("captcha/engine/symbol_latency", "Latency of a call to the symbol resolver", stats.UnitMilliseconds)
+	MeasureErrors            = stats.Int64("captcha/engine/errors", "Number of failed calls", stats.UnitDimensionless)
+	MeasureSymbolConfidence  = stats.Float64("captcha/engine/symbol_confidence", "Probability of the resolved symbols", stats.UnitDimensionless)
+	MeasureReports           = stats.Int64("captcha/engine/reports", "Number of reported results", stats.UnitDimensionless)
+)
+
+// The tags of the measures.
+var (
+	// KeyStage is the stage of an error: resolve, preprocess or symbol.
+	KeyStage = tag.MustNewKey("stage")
+	// KeyError is the type of an error, see ErrorType.
+	KeyError = tag.MustNewKey("error")
+	// KeyPosition is the position of a symbol in the captcha.
+	KeyPosition = tag.MustNewKey("position")
+	// KeyResult is the reported result: correct, incorrect or skipped.
+	KeyResult = tag.MustNewKey("result")
+)
+
+var (
+	latencyBounds    = view.Distribution(1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000)
+	confidenceBounds = view.Distribution(0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 0.95, 0.99)
+)
+
+// The views of the measures, DefaultViews holds all of them.
+var (
+	ResolveLatencyView = &view.View{
+		Name:        "captcha/engine/resolve_latency",
+		Description: "Latency distribution of resolving a captcha",
+		Measure:     MeasureResolveLatency,
+		Aggregation: latencyBounds,
+	}
+	PreprocessLatencyView = &view.View{
+		Name:        "captcha/engine/preprocess_latency",
+		Description: "Latency distribution of preprocessing a captcha",
+		Measure:     MeasurePreprocessLatency,
+		Aggregation: latencyBounds,
+	}
+	SymbolLatencyView = &view.View{
+		Name:        "captcha/engine/symbol_latency",
+		Description: "Latency distribution of the calls to the symbol resolver",
+		Measure:     MeasureSymbolLatency,
+		Aggregation: latencyBounds,
+	}
+	ErrorCountView = &view.View{
+		Name:        "captcha/engine/errors",
+		Description: "Number of failed calls, by stage and type of error",
+		Measure:     MeasureErrors,
+		TagKeys:     []tag.Key{KeyStage, KeyError},
+		Aggregation: view.Count(),
+	}
+	SymbolConfidenceView = &view.View{
+		Name:        "captcha/engine/symbol_confidence",
+		Description: "Probability distribution of the resolved symbols, by position",
+		Measure:     MeasureSymbolConfidence,
+		TagKeys:     []tag.Key{KeyPosition},
+		Aggregation: confidenceBounds,
+	}
+	ReportCountView = &view.View{
+		Name:        "captcha/engine/reports",
+		Description: "Number of reported results, by result: correct, incorrect or skipped for a low confidence",
+		Measure:     MeasureReports,
+		TagKeys:     []tag.Key{KeyResult},
+		Aggregation: view.Count(),
+	}
+
+	DefaultViews = []*view.View{
+		ResolveLatencyView,
+		PreprocessLatencyView,
+		SymbolLatencyView,
+		ErrorCountView,
+		SymbolConfidenceView,
+		ReportCountView,
+	}
+)
+
+// ErrorType returns the type of the error recorded with KeyError.
+func ErrorType(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.Is(err, ErrLimitExceeded):
+		return "limit_exceeded"
+	case errors.Is(err, ErrCaptchaLength):
+		return "length"
+	case errors.Is(err, ErrCaptchaConstraint):
+		return "constraint"
+	case errors.Is(err, ErrBatchMismatch):
+		return "batch_mismatch"
+	case errors.Is(err, ErrCaptchaInvalid):
+		return "invalid"
+	}
+	return "other"
+}
+
+// recordLatency records the time since start, and the error if any.
+func recordLatency(ctx context.Context, m *stats.Float64Measure, stage string, start time.Time, err error) {
+	stats.Record(ctx, m.M(float64(time.Since(start))/float64(time.Millisecond)))
+	if err != nil {
+		stats.RecordWithTags(ctx, []tag.Mutator{
+			tag.Upsert(KeyStage, stage),
+			tag.Upsert(KeyError, ErrorType(err)),
+		}, MeasureErrors.M(1))
+	}
+}
+
+// recordConfidence records the probability of each symbol of the result.
+func recordConfidence(ctx context.Context, result *CaptchaResult) {
+	for i, s := range result.Symbols {
+		stats.RecordWithTags(ctx, []tag.Mutator{
+			tag.Upsert(KeyPosition, strconv.Itoa(i)),
+		}, MeasureSymbolConfidence.M(float64(s.Probability)))
+	}
+}
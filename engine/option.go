@@ -1,15 +1,34 @@
 package engine
 
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"giautm.dev/captcha/binimg"
+)
+
 type EngineOption struct {
 	captchaLen   int
+	minLen       int
+	maxLen       int
+	blank        string
+	charset      SymbolFilter
+	charsets     []SymbolFilter
+	pattern      *regexp.Regexp
+	lenResolver  SymbolResolver
 	binaryWidth  int
+	concurrency  int
+	encoder      binimg.PositionEncoder
 	preprocessor Preprocessor
+	splitter     Splitter
 	symbol       SymbolResolver
 	stats        bool
 }
 
 type Option func(*EngineOption) error
 
+// WithBinaryWidth sets the width of the default binimg.LeftBar encoder.
 func WithBinaryWidth(width int) Option {
 	return func(opt *EngineOption) error {
 		opt.binaryWidth = width
@@ -24,22 +43,129 @@ func WithCaptchaLength(len int) Option {
 	}
 }
 
-func WithPreprocessor(preprocessor Preprocessor) Option {
+// WithCaptchaLengthRange accepts captchas of minLen to maxLen symbols, others
+// fail with ErrCaptchaLength. The default Splitter encodes maxLen positions,
+// the end of the captcha is found with WithBlankLabel or WithLengthResolver.
+func WithCaptchaLengthRange(minLen, maxLen int) Option {
+	return func(opt *EngineOption) error {
+		if minLen < 1 || maxLen < minLen {
+			return errors.New("engine: invalid captcha length range")
+		}
+		opt.captchaLen, opt.minLen, opt.maxLen = maxLen, minLen, maxLen
+		return nil
+	}
+}
+
+// WithBlankLabel sets the label the symbol model gives to the positions
+// past the end of the captcha. The captcha ends at the first blank symbol.
+func WithBlankLabel(label string) Option {
+	return func(opt *EngineOption) error {
+		opt.blank = label
+		return nil
+	}
+}
+
+// WithCharset allows only the characters of chars at every position.
+// The SymbolResolver is told the allowed symbols by SymbolFilterFromContext,
+// the captcha is then made of the most probable allowed candidates, or
+// ResolveImage fails with a ConstraintError.
+func WithCharset(chars string) Option {
+	return func(opt *EngineOption) error {
+		opt.charset = Charset(chars)
+		return nil
+	}
+}
+
+// WithPositionCharsets allows only the characters of charsets[i] at the
+// position i, an empty charset allows any symbol, as do the positions
+// past the charsets. It is combined with WithCharset.
+func WithPositionCharsets(charsets ...string) Option {
+	return func(opt *EngineOption) error {
+		opt.charsets = make([]SymbolFilter, len(charsets))
+		for i, chars := range charsets {
+			if chars != "" {
+				opt.charsets[i] = Charset(chars)
+			}
+		}
+		return nil
+	}
+}
+
+// WithPattern allows only the captchas matching the regular expression
+// as a whole. The captcha is the most probable combination of the symbols
+// and their alternatives that matches, or ResolveImage fails with
+// a ConstraintError.
+func WithPattern(expr string) Option {
+	return func(opt *EngineOption) error {
+		re, err := regexp.Compile(`^(?:` + expr + `)$`)
+		if err != nil {
+			return fmt.Errorf("engine: invalid pattern: %w", err)
+		}
+		opt.pattern = re
+		return nil
+	}
+}
+
+// WithLengthResolver sets a resolver of the length of the captcha, from the
+// preprocessed image. Its symbol must be the length as a decimal number.
+func WithLengthResolver(sr SymbolResolver) Option {
+	return func(opt *EngineOption) error {
+		opt.lenResolver = sr
+		return nil
+	}
+}
+
+// WithPositionEncoder sets the encoder of the position of the default
+// Splitter, it must be the one the model was trained with.
+// The default is binimg.LeftBar of the binary width.
+func WithPositionEncoder(enc binimg.PositionEncoder) Option {
 	return func(opt *EngineOption) error {
-		if opt.stats {
-			preprocessor = &StatsPreprocessor{preprocessor}
+		opt.encoder = enc
+		return nil
+	}
+}
+
+// WithConcurrency sets the maximum number of positions resolved in parallel.
+// The default is 1, which resolves the positions sequentially.
+func WithConcurrency(n int) Option {
+	return func(opt *EngineOption) error {
+		if n < 1 {
+			return errors.New("engine: concurrency must be positive")
 		}
+		opt.concurrency = n
+		return nil
+	}
+}
+
+func WithPreprocessor(preprocessor Preprocessor) Option {
+	return func(opt *EngineOption) error {
 		opt.preprocessor = preprocessor
 		return nil
 	}
 }
 
+// WithSplitter sets the way the image is split into symbols. The default
+// is a PositionSplitter of the captcha length and binary width.
+func WithSplitter(s Splitter) Option {
+	return func(opt *EngineOption) error {
+		opt.splitter = s
+		return nil
+	}
+}
+
 func WithSymbolResolver(sr SymbolResolver) Option {
 	return func(opt *EngineOption) error {
-		if opt.stats {
-			sr = &StatsSymbolResolver{sr}
-		}
 		opt.symbol = sr
 		return nil
 	}
 }
+
+// WithStats traces and records the metrics of the preprocessor and the
+// resolvers of the engine, whatever the order of the options.
+// See NewStatsCaptchaResolver to also instrument the engine itself.
+func WithStats() Option {
+	return func(opt *EngineOption) error {
+		opt.stats = true
+		return nil
+	}
+}
@@ -0,0 +1,249 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type (
+	// ProxyPool is an http.RoundTripper sending each request through the
+	// next healthy proxy of the pool. The proxies failing MaxFailures
+	// requests in a row are quarantined for a while.
+	//
+	// Set as the Proxies of a CaptchaSession, each attempt of the session
+	// goes through a single proxy, which is credited with the correctness
+	// of the results of the attempt.
+	ProxyPool struct {
+		mu          sync.Mutex
+		proxies     []*Proxy
+		next        int
+		strategy    ProxyStrategy
+		maxFailures int
+		quarantine  time.Duration
+		base        *http.Transport
+	}
+	// Proxy is an http.RoundTripper sending the requests through
+	// a proxy of a ProxyPool.
+	Proxy struct {
+		URL       *url.URL
+		pool      *ProxyPool
+		index     int
+		transport *http.Transport
+		// The fields below are guarded by the mutex of the pool.
+		requests, failures, consecutive int
+		correct, incorrect              int
+		until                           time.Time
+	}
+	// ProxyStats are the statistics of a proxy of a ProxyPool.
+	ProxyStats struct {
+		URL string
+		// Requests is the number of requests, Failures the number
+		// of them which failed at the transport.
+		Requests, Failures int
+		// Correct and Incorrect are the number of results reported
+		// for the attempts through the proxy.
+		Correct, Incorrect int
+		// Quarantined is the end of the quarantine, if the proxy is in one.
+		Quarantined time.Time
+	}
+	// ProxyStrategy is the way a ProxyPool picks the next proxy.
+	ProxyStrategy int
+	// ProxyOption is a function that sets an option on the ProxyPool.
+	ProxyOption func(*ProxyPool) error
+	proxyKey    struct{}
+)
+
+const (
+	// ProxyRoundRobin picks the healthy proxies in turn.
+	ProxyRoundRobin ProxyStrategy = iota
+	// ProxyLeastFailures picks the healthy proxy with the fewest failed
+	// requests, in turn between equals.
+	ProxyLeastFailures
+)
+
+var (
+	ErrNoProxy = errors.New("engine: no proxy available")
+)
+
+// WithProxyStrategy sets the way the next proxy is picked.
+// The default is ProxyRoundRobin.
+func WithProxyStrategy(s ProxyStrategy) ProxyOption {
+	return func(p *ProxyPool) error {
+		if s < ProxyRoundRobin || s > ProxyLeastFailures {
+			return errors.New("engine: unknown proxy strategy")
+		}
+		p.strategy = s
+		return nil
+	}
+}
+
+// WithQuarantine quarantines a proxy for d after maxFailures failed
+// requests in a row. The default is 3 failures and 1 minute.
+func WithQuarantine(maxFailures int, d time.Duration) ProxyOption {
+	return func(p *ProxyPool) error {
+		if maxFailures < 1 {
+			return errors.New("engine: max failures must be positive")
+		}
+		p.maxFailures, p.quarantine = maxFailures, d
+		return nil
+	}
+}
+
+// WithProxyTransport sets the transport cloned for each proxy.
+// The default is http.DefaultTransport, or a zero http.Transport
+// if it has been replaced by another RoundTripper.
+func WithProxyTransport(t *http.Transport) ProxyOption {
+	return func(p *ProxyPool) error {
+		p.base = t
+		return nil
+	}
+}
+
+// NewProxyPool creates a new ProxyPool of the proxy URLs.
+func NewProxyPool(proxies []string, opts ...ProxyOption) (*ProxyPool, error) {
+	if len(proxies) == 0 {
+		return nil, ErrNoProxy
+	}
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		base = &http.Transport{}
+	}
+	pp := &ProxyPool{
+		strategy:    ProxyRoundRobin,
+		maxFailures: 3,
+		quarantine:  time.Minute,
+		base:        base,
+	}
+	for _, fn := range opts {
+		if err := fn(pp); err != nil {
+			return nil, err
+		}
+	}
+	for i, raw := range proxies {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("engine: invalid proxy %q: %w", raw, err)
+		}
+		t := pp.base.Clone()
+		t.Proxy = http.ProxyURL(u)
+		pp.proxies = append(pp.proxies, &Proxy{URL: u, pool: pp, index: i, transport: t})
+	}
+	return pp, nil
+}
+
+// Pick returns the next healthy proxy, or ErrNoProxy if all of them
+// are quarantined.
+func (pp *ProxyPool) Pick() (*Proxy, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	now := time.Now()
+	var best *Proxy
+	for i := range pp.proxies {
+		p := pp.proxies[(pp.next+i)%len(pp.proxies)]
+		if now.Before(p.until) {
+			continue
+		}
+		if best == nil || p.failures < best.failures {
+			best = p
+		}
+		if pp.strategy == ProxyRoundRobin {
+			break
+		}
+	}
+	if best == nil {
+		return nil, ErrNoProxy
+	}
+	pp.next = (best.index + 1) % len(pp.proxies)
+	return best, nil
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (pp *ProxyPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	p, err := pp.Pick()
+	if err != nil {
+		return nil, err
+	}
+	return p.RoundTrip(req)
+}
+
+// Report credits the proxy of the attempt with the result, it implements
+// the ResultReporter interface.
+func (pp *ProxyPool) Report(ctx context.Context, _ *CaptchaResult, correct bool) error {
+	p, ok := ctx.Value(proxyKey{}).(*Proxy)
+	if !ok || p.pool != pp {
+		return nil
+	}
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	if correct {
+		p.correct++
+	} else {
+		p.incorrect++
+	}
+	return nil
+}
+
+// Stats returns the statistics of the proxies, in the order of the pool.
+func (pp *ProxyPool) Stats() []ProxyStats {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	now := time.Now()
+	stats := make([]ProxyStats, len(pp.proxies))
+	for i, p := range pp.proxies {
+		stats[i] = ProxyStats{
+			URL:       p.URL.Redacted(),
+			Requests:  p.requests,
+			Failures:  p.failures,
+			Correct:   p.correct,
+			Incorrect: p.incorrect,
+		}
+		if now.Before(p.until) {
+			stats[i].Quarantined = p.until
+		}
+	}
+	return stats
+}
+
+// RoundTrip implements the http.RoundTripper interface. The errors
+// of the transport and the 407 responses count as failures, the requests
+// canceled by their context count as neither failures nor successes.
+func (p *Proxy) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := p.transport.RoundTrip(req)
+	if err != nil && req.Context().Err() != nil {
+		return res, err
+	}
+	failed := err != nil || res.StatusCode == http.StatusProxyAuthRequired
+	pp := p.pool
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	p.requests++
+	if !failed {
+		p.consecutive = 0
+		return res, err
+	}
+	p.failures++
+	p.consecutive++
+	if p.consecutive >= pp.maxFailures {
+		p.consecutive = 0
+		p.until = time.Now().Add(pp.quarantine)
+	}
+	return res, err
+}
+
+// SuccessRate returns the share of the reported results which are
+// correct, or 0 if none is reported.
+func (s ProxyStats) SuccessRate() float64 {
+	if n := s.Correct + s.Incorrect; n > 0 {
+		return float64(s.Correct) / float64(n)
+	}
+	return 0
+}
+
+func withProxy(ctx context.Context, p *Proxy) context.Context {
+	return context.WithValue(ctx, proxyKey{}, p)
+}
@@ -0,0 +1,64 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestProxyPoolQuarantine(t *testing.T) {
+	// A proxy answering 407 to every request.
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+	}))
+	defer bad.Close()
+	pp, err := NewProxyPool([]string{bad.URL}, WithQuarantine(2, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		req, _ := http.NewRequest(http.MethodGet, "http://example.invalid/", nil)
+		res, err := pp.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if _, err := pp.Pick(); !errors.Is(err, ErrNoProxy) {
+		t.Errorf("Pick error = %v, want %v", err, ErrNoProxy)
+	}
+	if s := pp.Stats()[0]; s.Requests != 2 || s.Failures != 2 || s.Quarantined.IsZero() {
+		t.Errorf("stats = %+v, want 2 failed requests and a quarantine", s)
+	}
+}
+
+func TestProxyPoolCanceled(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+	pp, err := NewProxyPool([]string{slow.URL}, WithQuarantine(1, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://example.invalid/", nil)
+	if _, err := pp.RoundTrip(req); err == nil {
+		t.Fatal("RoundTrip succeeded, want the context error")
+	}
+	if s := pp.Stats()[0]; s.Requests != 0 || s.Failures != 0 || !s.Quarantined.IsZero() {
+		t.Errorf("stats = %+v, want the canceled request not counted", s)
+	}
+}
+
+func TestNewProxyPoolReplacedDefaultTransport(t *testing.T) {
+	defer func(t http.RoundTripper) { http.DefaultTransport = t }(http.DefaultTransport)
+	http.DefaultTransport = &rateLimitedTransport{}
+	if _, err := NewProxyPool([]string{"http://proxy.invalid:3128"}); err != nil {
+		t.Fatalf("NewProxyPool: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package engine
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type (
+	// SessionRunner runs many CaptchaSessions, with a bounded number of
+	// them at a time and a rate limit of the requests to each host.
+	SessionRunner struct {
+		// Concurrency is the number of sessions run at a time.
+		// The default is 1.
+		Concurrency int
+		// Rate is the number of requests per second to each host,
+		// 0 means no limit.
+		Rate float64
+		// Burst is the number of requests a host can receive at once,
+		// above the Rate. The default is 1.
+		Burst int
+
+		mu    sync.Mutex
+		hosts map[string]*tokenBucket
+	}
+	// SessionJob is a session run by the SessionRunner.
+	SessionJob struct {
+		// Job is the name of the job the session belongs to,
+		// the results are aggregated by job.
+		Job     string
+		Session *CaptchaSession
+	}
+	// SessionResult is the result of a SessionJob.
+	SessionResult struct {
+		Job string
+		// Index is the index of the session in the jobs given to Run.
+		Index    int
+		Value    any
+		Err      error
+		Duration time.Duration
+	}
+	// JobSummary aggregates the results of the sessions of a job.
+	JobSummary struct {
+		Succeeded int
+		Failed    int
+		// Errors is the number of failed sessions by ErrorType.
+		Errors map[string]int
+		// Duration is the total duration of the sessions.
+		Duration time.Duration
+	}
+	rateLimitedTransport struct {
+		runner *SessionRunner
+		base   http.RoundTripper
+	}
+	tokenBucket struct {
+		mu     sync.Mutex
+		rate   float64
+		burst  float64
+		tokens float64
+		last   time.Time
+	}
+)
+
+// Run starts the sessions of the jobs and streams their results, in the
+// order they complete. If ctx is canceled, the sessions not started yet
+// have a result with the error of ctx. The channel is closed once every
+// job has its result, and must be drained.
+//
+// Sessions without NewClient are run with a copy of the session whose
+// transport is rate limited by WrapTransport, see RoundTripper for the others.
+func (r *SessionRunner) Run(ctx context.Context, jobs []SessionJob) <-chan SessionResult {
+	concurrency := max(r.Concurrency, 1)
+	results := make(chan SessionResult, concurrency)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results <- r.run(ctx, i, jobs[i])
+			}
+		}()
+	}
+	go func() {
+		defer close(results)
+		defer wg.Wait()
+		defer close(next)
+		for i := range jobs {
+			select {
+			case next <- i:
+			case <-ctx.Done():
+				for ; i < len(jobs); i++ {
+					results <- SessionResult{Job: jobs[i].Job, Index: i, Err: ctx.Err()}
+				}
+				return
+			}
+		}
+	}()
+	return results
+}
+
+func (r *SessionRunner) run(ctx context.Context, i int, job SessionJob) SessionResult {
+	s := job.Session
+	if r.Rate > 0 && s.NewClient == nil {
+		c, wrap := *s, s.WrapTransport
+		c.WrapTransport = func(t http.RoundTripper) http.RoundTripper {
+			if wrap != nil {
+				t = wrap(t)
+			}
+			return r.RoundTripper(t)
+		}
+		s = &c
+	}
+	start := time.Now()
+	value, err := s.Start(ctx)
+	return SessionResult{
+		Job:      job.Job,
+		Index:    i,
+		Value:    value,
+		Err:      err,
+		Duration: time.Since(start),
+	}
+}
+
+// RoundTripper wraps the base RoundTripper, http.DefaultTransport if nil,
+// with the rate limit of the runner.
+func (r *SessionRunner) RoundTripper(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &rateLimitedTransport{runner: r, base: base}
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.runner.bucket(req.URL.Host).wait(req.Context()); err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
+
+func (r *SessionRunner) bucket(host string) *tokenBucket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hosts == nil {
+		r.hosts = map[string]*tokenBucket{}
+	}
+	b, ok := r.hosts[host]
+	if !ok {
+		burst := float64(max(r.Burst, 1))
+		b = &tokenBucket{rate: r.Rate, burst: burst, tokens: burst, last: time.Now()}
+		r.hosts[host] = b
+	}
+	return b
+}
+
+// wait takes a token from the bucket, waiting for it if needed.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	if b.rate <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	// The token is reserved now, the bucket goes below zero
+	// for the later callers to wait longer.
+	b.tokens--
+	d := time.Duration(-b.tokens / b.rate * float64(time.Second))
+	b.mu.Unlock()
+	if err := sleep(ctx, d); err != nil {
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Add adds the result of a session to the summary.
+func (s *JobSummary) Add(r SessionResult) {
+	s.Duration += r.Duration
+	if r.Err == nil {
+		s.Succeeded++
+		return
+	}
+	s.Failed++
+	if s.Errors == nil {
+		s.Errors = map[string]int{}
+	}
+	s.Errors[ErrorType(r.Err)]++
+}
+
+// Summarize drains the results and aggregates them by job.
+func Summarize(results <-chan SessionResult) map[string]*JobSummary {
+	summaries := map[string]*JobSummary{}
+	for r := range results {
+		s, ok := summaries[r.Job]
+		if !ok {
+			s = &JobSummary{}
+			summaries[r.Job] = s
+		}
+		s.Add(r)
+	}
+	return summaries
+}
@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    float64
+		calls    int
+		min, max time.Duration
+	}{
+		{"no limit", 0, 1, 10, 0, 20 * time.Millisecond},
+		{"burst", 20, 5, 5, 0, 20 * time.Millisecond},
+		{"rate", 100, 1, 6, 50 * time.Millisecond, 150 * time.Millisecond},
+		{"burst then rate", 100, 3, 6, 30 * time.Millisecond, 130 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &tokenBucket{rate: tt.rate, burst: tt.burst, tokens: tt.burst, last: time.Now()}
+			start := time.Now()
+			for range tt.calls {
+				if err := b.wait(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if d := time.Since(start); d < tt.min || d > tt.max {
+				t.Errorf("%d calls took %v, want between %v and %v", tt.calls, d, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestTokenBucketWaitCanceled(t *testing.T) {
+	b := &tokenBucket{rate: 1, burst: 1, tokens: 0, last: time.Now()}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.wait(ctx); err == nil {
+		t.Fatal("wait succeeded, want the context error")
+	}
+	// The token reserved by the canceled call is given back.
+	if b.tokens < -0.5 {
+		t.Errorf("tokens = %v after a canceled wait, want about 0", b.tokens)
+	}
+}
+
+// blockingSession returns a session whose Main blocks until
+// its context is done, counting the started sessions.
+func blockingSession(started *atomic.Int32) *CaptchaSession {
+	return &CaptchaSession{
+		Captcha: func(context.Context, HTTPDoer) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("")), nil
+		},
+		Main: func(ctx context.Context, _ HTTPDoer, _ string) (any, error) {
+			started.Add(1)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		Engine: fixedResolver{probability: 1},
+	}
+}
+
+func TestSessionRunnerCanceled(t *testing.T) {
+	var started atomic.Int32
+	jobs := make([]SessionJob, 10)
+	for i := range jobs {
+		jobs[i] = SessionJob{Job: "block", Session: blockingSession(&started)}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &SessionRunner{Concurrency: 3}
+	results := r.Run(ctx, jobs)
+	for started.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	done := make(chan map[string]*JobSummary)
+	go func() { done <- Summarize(results) }()
+	select {
+	case summaries := <-done:
+		s := summaries["block"]
+		// The sessions never started are canceled as well.
+		if s == nil || s.Failed != 10 || s.Succeeded != 0 {
+			t.Errorf("summary = %+v, want the 10 sessions failed", s)
+		}
+		if n := s.Errors["canceled"]; n != 10 {
+			t.Errorf("canceled errors = %d, want 10", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("results are not closed after the context is canceled")
+	}
+}
+
+func TestSessionRunnerRate(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		requests.Add(1)
+	}))
+	defer srv.Close()
+	var wrapped atomic.Int32
+	jobs := make([]SessionJob, 5)
+	for i := range jobs {
+		jobs[i] = SessionJob{Job: "rate", Session: &CaptchaSession{
+			// The runner limits the rate on top of the session wrapper.
+			WrapTransport: func(t http.RoundTripper) http.RoundTripper {
+				wrapped.Add(1)
+				return t
+			},
+			Captcha: func(ctx context.Context, c HTTPDoer) (io.ReadCloser, error) {
+				req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+				if err != nil {
+					return nil, err
+				}
+				res, err := c.Do(req)
+				if err != nil {
+					return nil, err
+				}
+				return res.Body, nil
+			},
+			Main: func(context.Context, HTTPDoer, string) (any, error) {
+				return nil, nil
+			},
+			Engine: fixedResolver{probability: 1},
+		}}
+	}
+	r := &SessionRunner{Concurrency: 5, Rate: 50, Burst: 1}
+	start := time.Now()
+	summaries := Summarize(r.Run(context.Background(), jobs))
+	if s := summaries["rate"]; s == nil || s.Succeeded != len(jobs) {
+		t.Fatalf("summary = %+v, want %d succeeded", s, len(jobs))
+	}
+	if n := wrapped.Load(); n != int32(len(jobs)) {
+		t.Errorf("session transports wrapped %d times, want %d", n, len(jobs))
+	}
+	// The first request takes the burst, the 4 others wait 20ms each.
+	if d := time.Since(start); d < 70*time.Millisecond {
+		t.Errorf("%d requests took %v, want at least 70ms at 50 per second", requests.Load(), d)
+	}
+}
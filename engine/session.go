@@ -3,9 +3,14 @@ package engine
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"math"
+	"math/rand/v2"
+	"net"
 	"net/http"
 	"net/http/cookiejar"
+	"syscall"
 	"time"
 )
 
@@ -16,14 +21,76 @@ type (
 		Captcha func(_ context.Context, c HTTPDoer) (io.ReadCloser, error)
 		// Main is the function will be invoked after the captcha is resolved.
 		Main func(_ context.Context, c HTTPDoer, captcha string) (any, error)
+		// Prepare is the optional function invoked once per attempt, before
+		// the captcha is fetched, to get the state the captcha is tied to,
+		// like a form token. The state is given to Captcha and Main by
+		// SessionStateFromContext.
+		//
+		// With Prepare, Main failing with ErrCaptchaInvalid only refetches
+		// the captcha, with the same client and state, up to RefetchCount
+		// times before the attempt fails.
+		Prepare func(_ context.Context, c HTTPDoer) (any, error)
+		// RefetchCount is the number of captchas refetched per attempt.
+		RefetchCount int
+		// MinConfidence is the confidence, see CaptchaResult.Confidence,
+		// below which a result is skipped: a new captcha is fetched without
+		// invoking Main. The results without confidence are never skipped.
+		MinConfidence float32
+		// MaxSkips is the number of results skipped in a row, the next
+		// result is submitted whatever its confidence. The default is 3,
+		// a negative value never skips.
+		MaxSkips int
 		// Engine is the captcha resolver.
 		Engine CaptchaResolver
+		// Reporter receives the results with their correctness, in addition
+		// to the Engine if it is a ResultReporter.
+		Reporter ResultReporter
 		// IDGenerator generates a new session ID.
 		IDGenerator IDGenerator
 		// RetryCount is the number of retries, 0 means no retry.
 		RetryCount int
+		// Retryable reports whether an attempt failing with the error is
+		// retried. The default retries on ErrLimitExceeded only.
+		Retryable func(err error) bool
+		// Backoff is the delay between attempts, the zero value
+		// retries immediately.
+		Backoff Backoff
+		// AttemptTimeout is the timeout of an attempt, 0 means no timeout.
+		AttemptTimeout time.Duration
+		// NewClient creates the HTTP client of the session. The default
+		// is an http.Client with a cookie jar, the Transport and RequestTimeout.
+		NewClient func() (HTTPDoer, error)
+		// RequestTimeout is the timeout of a request of the default client.
+		// The default is 10 seconds.
+		RequestTimeout time.Duration
 		// Transport is the HTTP transport used to make requests.
 		Transport http.RoundTripper
+		// Proxies replaces the Transport of the default client, every
+		// attempt then has a new client, going through the next proxy.
+		// The proxy is reported the correctness of the results.
+		Proxies *ProxyPool
+		// WrapTransport wraps the transport of the default client,
+		// the Transport or the proxy of the attempt, nil for the
+		// http.DefaultTransport.
+		WrapTransport func(http.RoundTripper) http.RoundTripper
+	}
+	// Backoff is an exponential backoff with jitter.
+	Backoff struct {
+		// Initial is the delay before the first retry.
+		Initial time.Duration
+		// Max caps the delay, 0 means no cap.
+		Max time.Duration
+		// Multiplier is the growth of the delay per retry,
+		// values below 1 are taken as 2.
+		Multiplier float64
+		// Jitter is the fraction of the delay which is randomized,
+		// between 0 and 1.
+		Jitter float64
+	}
+	// SkipReporter is notified of the results skipped for their low
+	// confidence, which are neither correct nor incorrect.
+	SkipReporter interface {
+		ReportSkip(ctx context.Context, result *CaptchaResult) error
 	}
 	HTTPDoer interface {
 		Do(*http.Request) (*http.Response, error)
@@ -31,8 +98,9 @@ type (
 	IDGenerator interface {
 		NewID() string
 	}
-	IDFunc    func() string
-	sessionID struct{}
+	IDFunc       func() string
+	sessionID    struct{}
+	sessionState struct{}
 )
 
 var (
@@ -41,47 +109,275 @@ var (
 
 // Start starts the captcha session, it will fetch the captcha image,
 // resolve the captcha, and then invoke the main function.
+//
+// The failed attempts are retried as classified by Retryable, after the
+// Backoff delay. It fails with ErrLimitExceeded, wrapping the error of the
+// last attempt, when no retry is left.
+//
+// With Prepare, every attempt starts with a new client and state, and
+// an attempt out of refetches fails with ErrLimitExceeded, retried by
+// the default Retryable.
 func (h *CaptchaSession) Start(ctx context.Context) (any, error) {
-	jar, err := cookiejar.New(nil)
+	if h.Prepare != nil {
+		return h.retry(ctx, h.RetryCount, h.prepared)
+	}
+	if h.Proxies != nil {
+		return h.retry(ctx, h.RetryCount, func(ctx context.Context) (any, error) {
+			ctx, client, err := h.newClient(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return h.solve(ctx, client)
+		})
+	}
+	_, client, err := h.newClient(ctx)
 	if err != nil {
 		return nil, err
 	}
-	client := &http.Client{
-		Jar:       jar,
-		Timeout:   time.Second * 10,
-		Transport: h.Transport,
+	return h.retry(ctx, h.RetryCount, func(ctx context.Context) (any, error) {
+		return h.solve(ctx, client)
+	})
+}
+
+// prepared is an attempt of a session with Prepare.
+func (h *CaptchaSession) prepared(ctx context.Context) (any, error) {
+	ctx, client, err := h.newClient(ctx)
+	if err != nil {
+		return nil, err
 	}
-	handler := func(ctx context.Context) (any, error) {
-		file, err := h.Captcha(ctx, client)
-		if err != nil {
+	state, err := h.Prepare(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	ctx = context.WithValue(ctx, sessionState{}, state)
+	for i := 0; ; i++ {
+		t, err := h.solve(ctx, client)
+		switch {
+		case err == nil:
+			return t, nil
+		case !errors.Is(err, ErrCaptchaInvalid):
+			return t, err
+		case i >= h.RefetchCount:
+			return nil, fmt.Errorf("%w: %w", ErrLimitExceeded, err)
+		}
+		if err := sleep(ctx, h.Backoff.Delay(i)); err != nil {
 			return nil, err
 		}
-		defer file.Close()
-		result, err := h.Engine.ResolveFile(ctx, file)
-		if err != nil {
+	}
+}
+
+// solve fetches and resolves the captcha, then invokes the main function.
+// The low confidence results are skipped up to MaxSkips times.
+func (h *CaptchaSession) solve(ctx context.Context, client HTTPDoer) (any, error) {
+	maxSkips := h.MaxSkips
+	if maxSkips == 0 {
+		maxSkips = 3
+	}
+	var result *CaptchaResult
+	for skips := 0; ; skips++ {
+		var err error
+		if result, err = h.fetch(ctx, client); err != nil {
 			return nil, err
 		}
-		t, err := h.Main(ctx, client, result.Captcha)
-		if e, ok := h.Engine.(ResultReporter); ok {
-			if err == nil {
-				e.Report(ctx, result, true)
-			} else if errors.Is(err, ErrCaptchaInvalid) {
-				e.Report(ctx, result, false)
-			}
+		if skips >= maxSkips || !h.lowConfidence(result) {
+			break
 		}
-		return t, err
+		h.reportSkip(ctx, result)
 	}
-	for i := 0; i <= h.RetryCount; i++ {
-		switch t, err := handler(WithSessionID(ctx, h.IDGenerator)); {
+	t, err := h.Main(ctx, client, result.Captcha)
+	if err == nil {
+		h.report(ctx, result, true)
+	} else if errors.Is(err, ErrCaptchaInvalid) {
+		h.report(ctx, result, false)
+	}
+	return t, err
+}
+
+// retry calls the handler until it succeeds, fails with an error which is
+// not retryable, or the retries are exhausted.
+func (h *CaptchaSession) retry(ctx context.Context, retries int, handler func(context.Context) (any, error)) (any, error) {
+	retryable := h.Retryable
+	if retryable == nil {
+		retryable = RetryOn(ErrLimitExceeded)
+	}
+	var lastErr error
+	for i := 0; i <= retries; i++ {
+		if i > 0 {
+			if err := sleep(ctx, h.Backoff.Delay(i-1)); err != nil {
+				return nil, err
+			}
+		}
+		t, err := h.attempt(WithSessionID(ctx, h.IDGenerator), handler)
+		switch {
 		case err == nil:
 			return t, nil
-		case errors.Is(err, ErrLimitExceeded):
-			continue
-		default:
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case !retryable(err):
 			return t, err
 		}
+		lastErr = err
+	}
+	if errors.Is(lastErr, ErrLimitExceeded) {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("%w: %w", ErrLimitExceeded, lastErr)
+}
+
+func (h *CaptchaSession) attempt(ctx context.Context, handler func(context.Context) (any, error)) (any, error) {
+	if h.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.AttemptTimeout)
+		defer cancel()
+	}
+	return handler(ctx)
+}
+
+// newClient creates the client of an attempt, the returned context
+// holds the proxy of the client, if any.
+func (h *CaptchaSession) newClient(ctx context.Context) (context.Context, HTTPDoer, error) {
+	if h.NewClient != nil {
+		c, err := h.NewClient()
+		return ctx, c, err
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return ctx, nil, err
+	}
+	timeout := h.RequestTimeout
+	if timeout == 0 {
+		timeout = time.Second * 10
+	}
+	transport := h.Transport
+	if h.Proxies != nil {
+		p, err := h.Proxies.Pick()
+		if err != nil {
+			return ctx, nil, err
+		}
+		ctx, transport = withProxy(ctx, p), p
+	}
+	if h.WrapTransport != nil {
+		transport = h.WrapTransport(transport)
+	}
+	return ctx, &http.Client{
+		Jar:       jar,
+		Timeout:   timeout,
+		Transport: transport,
+	}, nil
+}
+
+// Delay returns the delay before the retry n, counted from 0.
+func (b Backoff) Delay(n int) time.Duration {
+	if b.Initial <= 0 {
+		return 0
+	}
+	mult := b.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+	d := float64(b.Initial) * math.Pow(mult, float64(n))
+	if b.Max > 0 {
+		d = min(d, float64(b.Max))
+	}
+	// Without Max the delay grows past the largest Duration,
+	// the float conversion would overflow.
+	d = min(d, math.MaxInt64)
+	jitter := min(max(b.Jitter, 0), 1)
+	if delay := d*(1-jitter) + rand.Float64()*d*jitter; delay < math.MaxInt64 {
+		return time.Duration(delay)
+	}
+	return math.MaxInt64
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RetryOn returns a Retryable retrying on the errors matching a target.
+func RetryOn(targets ...error) func(error) bool {
+	return func(err error) bool {
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RetryAny returns a Retryable retrying on the errors any of fns retries on.
+func RetryAny(fns ...func(error) bool) func(error) bool {
+	return func(err error) bool {
+		for _, fn := range fns {
+			if fn(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// IsTransient reports whether the error is a transient network error:
+// a timeout, including the AttemptTimeout, a reset or refused connection,
+// or a connection closed while reading the response.
+func IsTransient(err error) bool {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.ECONNREFUSED):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// fetch fetches and resolves the captcha.
+func (h *CaptchaSession) fetch(ctx context.Context, client HTTPDoer) (*CaptchaResult, error) {
+	file, err := h.Captcha(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return h.Engine.ResolveFile(ctx, file)
+}
+
+func (h *CaptchaSession) lowConfidence(result *CaptchaResult) bool {
+	c, ok := result.Confidence()
+	return ok && c < h.MinConfidence
+}
+
+func (h *CaptchaSession) reportSkip(ctx context.Context, result *CaptchaResult) {
+	if e, ok := h.Engine.(SkipReporter); ok {
+		e.ReportSkip(ctx, result)
+	}
+	if r, ok := h.Reporter.(SkipReporter); ok {
+		r.ReportSkip(ctx, result)
+	}
+}
+
+func (h *CaptchaSession) report(ctx context.Context, result *CaptchaResult, correct bool) {
+	if e, ok := h.Engine.(ResultReporter); ok {
+		e.Report(ctx, result, correct)
+	}
+	if h.Reporter != nil {
+		h.Reporter.Report(ctx, result, correct)
+	}
+	if h.Proxies != nil {
+		h.Proxies.Report(ctx, result, correct)
 	}
-	return nil, ErrLimitExceeded
 }
 
 // NewID returns a new session ID.
@@ -100,6 +396,12 @@ func SessionIDFromContext(ctx context.Context) string {
 	return ""
 }
 
+// SessionStateFromContext returns the state returned by the Prepare
+// function of the session, or nil.
+func SessionStateFromContext(ctx context.Context) any {
+	return ctx.Value(sessionState{})
+}
+
 func WithSessionID(ctx context.Context, gen IDGenerator) context.Context {
 	if gen == nil {
 		return ctx
@@ -0,0 +1,369 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fixedResolver resolves every captcha to a single symbol of
+// the probability.
+type fixedResolver struct {
+	probability float32
+}
+
+func (r fixedResolver) ResolveFile(ctx context.Context, _ io.Reader) (*CaptchaResult, error) {
+	return r.ResolveImage(ctx, nil)
+}
+
+func (r fixedResolver) ResolveImage(context.Context, image.Image) (*CaptchaResult, error) {
+	return &CaptchaResult{
+		Captcha: "a",
+		Length:  1,
+		Symbols: []SymbolResult{{Symbol: "a", Probability: r.probability}},
+	}, nil
+}
+
+func TestSessionMaxSkips(t *testing.T) {
+	tests := []struct {
+		name          string
+		minConfidence float32
+		maxSkips      int
+		want          int
+	}{
+		{"default", 0.9, 0, 4},
+		{"one", 0.9, 1, 2},
+		{"never", 0.9, -1, 1},
+		{"confident", 0.5, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetches := 0
+			s := &CaptchaSession{
+				Captcha: func(context.Context, HTTPDoer) (io.ReadCloser, error) {
+					fetches++
+					return io.NopCloser(strings.NewReader("")), nil
+				},
+				Main: func(context.Context, HTTPDoer, string) (any, error) {
+					return nil, nil
+				},
+				MinConfidence: tt.minConfidence,
+				MaxSkips:      tt.maxSkips,
+				Engine:        fixedResolver{probability: 0.5},
+			}
+			if _, err := s.Start(context.Background()); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			if fetches != tt.want {
+				t.Errorf("fetched %d captchas, want %d", fetches, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  Backoff
+		n        int
+		min, max time.Duration
+	}{
+		{"zero value", Backoff{}, 3, 0, 0},
+		{"initial", Backoff{Initial: 100 * time.Millisecond}, 0, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"doubled", Backoff{Initial: 100 * time.Millisecond}, 3, 800 * time.Millisecond, 800 * time.Millisecond},
+		{"multiplier", Backoff{Initial: time.Second, Multiplier: 3}, 2, 9 * time.Second, 9 * time.Second},
+		{"multiplier below 1", Backoff{Initial: time.Second, Multiplier: 0.5}, 1, 2 * time.Second, 2 * time.Second},
+		{"max", Backoff{Initial: time.Second, Max: 5 * time.Second}, 10, 5 * time.Second, 5 * time.Second},
+		{"no max", Backoff{Initial: time.Second}, 1000, math.MaxInt64, math.MaxInt64},
+		{"no max with jitter", Backoff{Initial: time.Second, Jitter: 1}, 1000, 0, math.MaxInt64},
+		{"jitter", Backoff{Initial: time.Second, Jitter: 0.5}, 0, 500 * time.Millisecond, time.Second},
+		{"jitter above 1", Backoff{Initial: time.Second, Jitter: 2}, 0, 0, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				if d := tt.backoff.Delay(tt.n); d < tt.min || d > tt.max {
+					t.Fatalf("Delay(%d) = %v, want between %v and %v", tt.n, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+// timeoutError is a net.Error, timed out or not.
+type timeoutError bool
+
+func (e timeoutError) Error() string   { return "network error" }
+func (e timeoutError) Timeout() bool   { return bool(e) }
+func (e timeoutError) Temporary() bool { return false }
+
+func TestRetryable(t *testing.T) {
+	errFoo, errBar := errors.New("foo"), errors.New("bar")
+	tests := []struct {
+		name      string
+		retryable func(error) bool
+		err       error
+		want      bool
+	}{
+		{"on", RetryOn(errFoo, errBar), errBar, true},
+		{"on wrapped", RetryOn(errFoo), fmt.Errorf("attempt: %w", errFoo), true},
+		{"on other", RetryOn(errFoo), errBar, false},
+		{"on nothing", RetryOn(), errFoo, false},
+		{"any", RetryAny(RetryOn(errFoo), IsTransient), io.ErrUnexpectedEOF, true},
+		{"any other", RetryAny(RetryOn(errFoo), IsTransient), errBar, false},
+		{"any of none", RetryAny(), errFoo, false},
+		{"deadline", IsTransient, context.DeadlineExceeded, true},
+		{"canceled", IsTransient, context.Canceled, false},
+		{"unexpected eof", IsTransient, fmt.Errorf("read body: %w", io.ErrUnexpectedEOF), true},
+		{"eof", IsTransient, io.EOF, false},
+		{"reset", IsTransient, &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"refused", IsTransient, &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"timeout", IsTransient, timeoutError(true), true},
+		{"not a timeout", IsTransient, timeoutError(false), false},
+		{"other", IsTransient, errFoo, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.retryable(tt.err); got != tt.want {
+				t.Errorf("retryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionRetry(t *testing.T) {
+	errTransient, errFatal := errors.New("transient"), errors.New("fatal")
+	tests := []struct {
+		name       string
+		retryable  func(error) bool
+		retryCount int
+		// errs are the errors of Main, one per attempt, it succeeds after.
+		errs         []error
+		wantAttempts int
+		wantErr      []error
+	}{
+		{"success", nil, 2, nil, 1, nil},
+		{"default not retried", nil, 2, []error{errTransient}, 1, []error{errTransient}},
+		{"default retries the limit", nil, 2, []error{ErrLimitExceeded}, 2, nil},
+		{"retried", RetryOn(errTransient), 2, []error{errTransient, errTransient}, 3, nil},
+		{"exhausted", RetryOn(errTransient), 1, []error{errTransient, errTransient}, 2, []error{ErrLimitExceeded, errTransient}},
+		{"not retryable", RetryOn(errTransient), 3, []error{errTransient, errFatal}, 2, []error{errFatal}},
+		{"no retry", RetryOn(errTransient), 0, []error{errTransient}, 1, []error{ErrLimitExceeded, errTransient}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			var ids []string
+			s := &CaptchaSession{
+				Captcha: func(context.Context, HTTPDoer) (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("")), nil
+				},
+				Main: func(ctx context.Context, _ HTTPDoer, _ string) (any, error) {
+					attempts++
+					ids = append(ids, SessionIDFromContext(ctx))
+					if attempts <= len(tt.errs) {
+						return nil, tt.errs[attempts-1]
+					}
+					return "done", nil
+				},
+				Engine:      fixedResolver{probability: 1},
+				IDGenerator: IDFunc(func() string { return fmt.Sprint("id-", attempts) }),
+				RetryCount:  tt.retryCount,
+				Retryable:   tt.retryable,
+				Backoff:     Backoff{Initial: time.Millisecond},
+			}
+			got, err := s.Start(context.Background())
+			if attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.wantAttempts)
+			}
+			if ids[0] != "id-0" || ids[len(ids)-1] != fmt.Sprint("id-", attempts-1) {
+				t.Errorf("session IDs = %v, want a new one per attempt", ids)
+			}
+			if tt.wantErr == nil {
+				if err != nil || got != "done" {
+					t.Errorf("Start = %v, %v, want done", got, err)
+				}
+				return
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("Start error = %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSessionRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	s := &CaptchaSession{
+		Captcha: func(context.Context, HTTPDoer) (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader("")), nil
+		},
+		Main: func(context.Context, HTTPDoer, string) (any, error) {
+			attempts++
+			cancel()
+			return nil, ErrLimitExceeded
+		},
+		Engine:     fixedResolver{probability: 1},
+		RetryCount: 3,
+		Backoff:    Backoff{Initial: time.Hour},
+	}
+	if _, err := s.Start(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Start error = %v, want %v", err, context.Canceled)
+	}
+	if attempts != 1 {
+		t.Errorf("%d attempts, want 1", attempts)
+	}
+}
+
+// stubClient is the HTTPDoer created by NewClient, never used.
+type stubClient struct{ id int }
+
+func (stubClient) Do(*http.Request) (*http.Response, error) {
+	return nil, errors.New("no request expected")
+}
+
+func TestSessionPrepared(t *testing.T) {
+	errPrepare, errMain := errors.New("no form token"), errors.New("form expired")
+	tests := []struct {
+		name         string
+		refetchCount int
+		retryCount   int
+		prepareErr   error
+		// mainErrs are the errors of Main, one per captcha, it succeeds after.
+		mainErrs     []error
+		wantClients  int
+		wantCaptchas int
+		wantErr      []error
+	}{
+		{
+			name:         "first captcha",
+			refetchCount: 2,
+			wantClients:  1,
+			wantCaptchas: 1,
+		},
+		{
+			name:         "refetched",
+			refetchCount: 2,
+			mainErrs:     []error{ErrCaptchaInvalid, ErrCaptchaInvalid},
+			wantClients:  1,
+			wantCaptchas: 3,
+		},
+		{
+			name:         "out of refetches",
+			refetchCount: 1,
+			mainErrs:     []error{ErrCaptchaInvalid, ErrCaptchaInvalid},
+			wantClients:  1,
+			wantCaptchas: 2,
+			wantErr:      []error{ErrLimitExceeded, ErrCaptchaInvalid},
+		},
+		{
+			name:         "restarted",
+			refetchCount: 1,
+			retryCount:   2,
+			mainErrs:     []error{ErrCaptchaInvalid, ErrCaptchaInvalid, ErrCaptchaInvalid},
+			wantClients:  2,
+			wantCaptchas: 4,
+		},
+		{
+			name:         "out of restarts",
+			refetchCount: 1,
+			retryCount:   2,
+			mainErrs:     []error{ErrCaptchaInvalid, ErrCaptchaInvalid, ErrCaptchaInvalid, ErrCaptchaInvalid, ErrCaptchaInvalid, ErrCaptchaInvalid},
+			wantClients:  3,
+			wantCaptchas: 6,
+			wantErr:      []error{ErrLimitExceeded, ErrCaptchaInvalid},
+		},
+		{
+			name:         "other error",
+			refetchCount: 2,
+			retryCount:   2,
+			mainErrs:     []error{errMain},
+			wantClients:  1,
+			wantCaptchas: 1,
+			wantErr:      []error{errMain},
+		},
+		{
+			name:         "prepare failed",
+			refetchCount: 2,
+			retryCount:   2,
+			prepareErr:   errPrepare,
+			wantClients:  1,
+			wantCaptchas: 0,
+			wantErr:      []error{errPrepare},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var clients, prepares, captchas, mains int
+			// check that a captcha is fetched and submitted with
+			// the client and the state of the current attempt.
+			check := func(ctx context.Context, c HTTPDoer) {
+				if c != (stubClient{id: clients}) {
+					t.Errorf("client = %v, want %d", c, clients)
+				}
+				if state := SessionStateFromContext(ctx); state != prepares {
+					t.Errorf("state = %v, want %d", state, prepares)
+				}
+			}
+			s := &CaptchaSession{
+				NewClient: func() (HTTPDoer, error) {
+					clients++
+					return stubClient{id: clients}, nil
+				},
+				Prepare: func(_ context.Context, c HTTPDoer) (any, error) {
+					prepares++
+					if c != (stubClient{id: clients}) {
+						t.Errorf("client = %v, want %d", c, clients)
+					}
+					return prepares, tt.prepareErr
+				},
+				Captcha: func(ctx context.Context, c HTTPDoer) (io.ReadCloser, error) {
+					captchas++
+					check(ctx, c)
+					return io.NopCloser(strings.NewReader("")), nil
+				},
+				Main: func(ctx context.Context, c HTTPDoer, _ string) (any, error) {
+					mains++
+					check(ctx, c)
+					if mains <= len(tt.mainErrs) {
+						return nil, tt.mainErrs[mains-1]
+					}
+					return "done", nil
+				},
+				Engine:       fixedResolver{probability: 1},
+				RefetchCount: tt.refetchCount,
+				RetryCount:   tt.retryCount,
+			}
+			got, err := s.Start(context.Background())
+			if clients != tt.wantClients || prepares != tt.wantClients {
+				t.Errorf("%d clients and %d prepares, want %d", clients, prepares, tt.wantClients)
+			}
+			if captchas != tt.wantCaptchas {
+				t.Errorf("%d captchas, want %d", captchas, tt.wantCaptchas)
+			}
+			if tt.wantErr == nil {
+				if err != nil || got != "done" {
+					t.Errorf("Start = %v, %v, want done", got, err)
+				}
+				return
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("Start error = %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"image"
 	"io"
+	"time"
 
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 	"go.opencensus.io/trace"
 )
 
@@ -15,35 +18,186 @@ type (
 	StatsSymbolResolver struct {
 		SymbolResolver
 	}
+	StatsScoredSymbolResolver struct {
+		StatsSymbolResolver
+	}
+	StatsBatchSymbolResolver struct {
+		StatsSymbolResolver
+	}
+	StatsScoredBatchSymbolResolver struct {
+		StatsScoredSymbolResolver
+	}
 	StatsCaptchaResolver struct {
 		CaptchaResolver
 	}
 )
 
-func (s *StatsPreprocessor) Transform(ctx context.Context, img image.Image) (image.Image, error) {
-	ctx, span := trace.StartSpan(ctx, "engine.Preprocess")
+func (s *StatsPreprocessor) Transform(ctx context.Context, img image.Image) (_ image.Image, err error) {
+	ctx, span := startSpan(ctx, "engine.Preprocess")
 	defer span.End()
+	defer func(start time.Time) {
+		recordLatency(ctx, MeasurePreprocessLatency, "preprocess", start, err)
+	}(time.Now())
 	return s.Preprocessor.Transform(ctx, img)
 }
 
-func (s *StatsSymbolResolver) SymbolResolve(ctx context.Context, img image.Image) (string, error) {
-	ctx, span := trace.StartSpan(ctx, "engine.SymbolResolve")
+func (s *StatsSymbolResolver) SymbolResolve(ctx context.Context, img image.Image) (_ string, err error) {
+	ctx, span := startSpan(ctx, "engine.SymbolResolve")
 	defer span.End()
+	defer func(start time.Time) {
+		recordLatency(ctx, MeasureSymbolLatency, "symbol", start, err)
+	}(time.Now())
 	return s.SymbolResolver.SymbolResolve(ctx, img)
 }
 
+func (s *StatsScoredSymbolResolver) SymbolResolveScored(ctx context.Context, img image.Image) (_ *SymbolResult, err error) {
+	ctx, span := startSpan(ctx, "engine.SymbolResolve")
+	defer span.End()
+	defer func(start time.Time) {
+		recordLatency(ctx, MeasureSymbolLatency, "symbol", start, err)
+	}(time.Now())
+	return s.SymbolResolver.(ScoredSymbolResolver).SymbolResolveScored(ctx, img)
+}
+
+func (s *StatsBatchSymbolResolver) SymbolResolveBatch(ctx context.Context, imgs []image.Image) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "engine.SymbolResolveBatch")
+	defer span.End()
+	defer func(start time.Time) {
+		recordLatency(ctx, MeasureSymbolLatency, "symbol", start, err)
+	}(time.Now())
+	return s.SymbolResolver.(BatchSymbolResolver).SymbolResolveBatch(ctx, imgs)
+}
+
+func (s *StatsScoredBatchSymbolResolver) SymbolResolveBatch(ctx context.Context, imgs []image.Image) (_ []string, err error) {
+	ctx, span := startSpan(ctx, "engine.SymbolResolveBatch")
+	defer span.End()
+	defer func(start time.Time) {
+		recordLatency(ctx, MeasureSymbolLatency, "symbol", start, err)
+	}(time.Now())
+	return s.SymbolResolver.(BatchSymbolResolver).SymbolResolveBatch(ctx, imgs)
+}
+
+func (s *StatsScoredBatchSymbolResolver) SymbolResolveScoredBatch(ctx context.Context, imgs []image.Image) (_ []SymbolResult, err error) {
+	ctx, span := startSpan(ctx, "engine.SymbolResolveBatch")
+	defer span.End()
+	defer func(start time.Time) {
+		recordLatency(ctx, MeasureSymbolLatency, "symbol", start, err)
+	}(time.Now())
+	return s.SymbolResolver.(ScoredBatchSymbolResolver).SymbolResolveScoredBatch(ctx, imgs)
+}
+
+// NewStatsCaptchaResolver creates a new engine with WithStats,
+// and instruments the engine itself.
+func NewStatsCaptchaResolver(opts ...Option) (*StatsCaptchaResolver, error) {
+	e, err := NewCaptchaResolveEngine(append(opts, WithStats())...)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsCaptchaResolver{CaptchaResolver: e}, nil
+}
+
+// startSpan starts a span with the session ID of the context.
+func startSpan(ctx context.Context, name string) (context.Context, *trace.Span) {
+	ctx, span := trace.StartSpan(ctx, name)
+	if id := SessionIDFromContext(ctx); id != "" {
+		span.AddAttributes(trace.StringAttribute("captcha.session_id", id))
+	}
+	return ctx, span
+}
+
+// NewStatsSymbolResolver wraps the SymbolResolver with tracing,
+// keeping the scored and batch capabilities of the resolver.
+func NewStatsSymbolResolver(sr SymbolResolver) SymbolResolver {
+	switch sr.(type) {
+	case ScoredBatchSymbolResolver:
+		return &StatsScoredBatchSymbolResolver{StatsScoredSymbolResolver{StatsSymbolResolver{sr}}}
+	case ScoredSymbolResolver:
+		return &StatsScoredSymbolResolver{StatsSymbolResolver{sr}}
+	case BatchSymbolResolver:
+		return &StatsBatchSymbolResolver{StatsSymbolResolver{sr}}
+	}
+	return &StatsSymbolResolver{sr}
+}
+
+// Report counts the result as correct or incorrect, then reports it
+// to the CaptchaResolver if it is a ResultReporter.
 func (s *StatsCaptchaResolver) Report(ctx context.Context, captcha *CaptchaResult, correct bool) error {
+	ctx, span := startSpan(ctx, "engine.Report")
+	defer span.End()
+	span.AddAttributes(
+		trace.StringAttribute("captcha.result", captcha.Captcha),
+		trace.BoolAttribute("captcha.correct", correct),
+	)
+	result := "incorrect"
+	if correct {
+		result = "correct"
+	}
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(KeyResult, result)}, MeasureReports.M(1))
+	if r, ok := s.CaptchaResolver.(ResultReporter); ok {
+		return r.Report(ctx, captcha, correct)
+	}
+	return nil
+}
+
+// ReportSkip counts the result as skipped, then reports it to the
+// CaptchaResolver if it is a SkipReporter.
+// ValidateReport implements the ReportValidator interface,
+// if the wrapped resolver does.
+func (s *StatsCaptchaResolver) ValidateReport(captcha *CaptchaResult, correct bool) error {
+	if v, ok := s.CaptchaResolver.(ReportValidator); ok {
+		return v.ValidateReport(captcha, correct)
+	}
+	return nil
+}
+
+func (s *StatsCaptchaResolver) ReportSkip(ctx context.Context, captcha *CaptchaResult) error {
+	ctx, span := startSpan(ctx, "engine.ReportSkip")
+	defer span.End()
+	span.AddAttributes(trace.StringAttribute("captcha.result", captcha.Captcha))
+	if c, ok := captcha.Confidence(); ok {
+		span.AddAttributes(trace.Float64Attribute("captcha.confidence", float64(c)))
+	}
+	stats.RecordWithTags(ctx, []tag.Mutator{tag.Upsert(KeyResult, "skipped")}, MeasureReports.M(1))
+	if r, ok := s.CaptchaResolver.(SkipReporter); ok {
+		return r.ReportSkip(ctx, captcha)
+	}
 	return nil
 }
 
 func (s *StatsCaptchaResolver) ResolveFile(ctx context.Context, r io.Reader) (*CaptchaResult, error) {
-	ctx, span := trace.StartSpan(ctx, "engine.ResolveFile")
+	ctx, span := startSpan(ctx, "engine.ResolveFile")
 	defer span.End()
-	return s.CaptchaResolver.ResolveFile(ctx, r)
+	return s.record(ctx, span, func(ctx context.Context) (*CaptchaResult, error) {
+		return s.CaptchaResolver.ResolveFile(ctx, r)
+	})
 }
 
 func (s *StatsCaptchaResolver) ResolveImage(ctx context.Context, img image.Image) (*CaptchaResult, error) {
-	ctx, span := trace.StartSpan(ctx, "engine.ResolveImage")
+	ctx, span := startSpan(ctx, "engine.ResolveImage")
 	defer span.End()
-	return s.CaptchaResolver.ResolveImage(ctx, img)
+	return s.record(ctx, span, func(ctx context.Context) (*CaptchaResult, error) {
+		return s.CaptchaResolver.ResolveImage(ctx, img)
+	})
+}
+
+// record records the latency of resolve, its error, and the
+// confidence of the symbols of its result, on the span too.
+func (s *StatsCaptchaResolver) record(ctx context.Context, span *trace.Span, resolve func(context.Context) (*CaptchaResult, error)) (*CaptchaResult, error) {
+	start := time.Now()
+	result, err := resolve(ctx)
+	recordLatency(ctx, MeasureResolveLatency, "resolve", start, err)
+	if err != nil {
+		span.AddAttributes(trace.StringAttribute("captcha.error", ErrorType(err)))
+		span.SetStatus(trace.Status{Code: trace.StatusCodeUnknown, Message: err.Error()})
+		return nil, err
+	}
+	recordConfidence(ctx, result)
+	span.AddAttributes(
+		trace.Int64Attribute("captcha.length", int64(result.Length)),
+		trace.StringAttribute("captcha.result", result.Captcha),
+	)
+	if c, ok := result.Confidence(); ok {
+		span.AddAttributes(trace.Float64Attribute("captcha.confidence", float64(c)))
+	}
+	return result, nil
 }
@@ -21,51 +21,49 @@ func ApplyFishEye(dest draw.Image, src image.Image, distance int) draw.Image {
 	midX, midY, radius := b.Dx()/2, b.Dy()/2, float64(distance)
 	for y := b.Min.Y; y < b.Max.Y; y++ {
 		for x := b.Min.X; x < b.Max.X; x++ {
-			relX, relY := float64(x-midX), float64(y-midY)
-			if r := math.Sqrt(relX*relX + relY*relY); r < radius {
-				tmp := formula(r/radius) * radius / r
-				x2, y2 := midX+(int)(tmp*relX), midY+(int)(tmp*relY)
-				dest.Set(x, y, src.At(x2, y2))
-			} else {
-				dest.Set(x, y, src.At(x, y))
-			}
+			dest.Set(x, y, src.At(fishEyeSource(x, y, midX, midY, radius)))
 		}
 	}
 	return dest
 }
 
+// fishEyeSource returns the pixel ApplyFishEye samples for x, y.
+func fishEyeSource(x, y, midX, midY int, radius float64) (int, int) {
+	relX, relY := float64(x-midX), float64(y-midY)
+	if r := math.Sqrt(relX*relX + relY*relY); r < radius {
+		tmp := formula(r/radius) * radius / r
+		return midX + (int)(tmp*relX), midY + (int)(tmp*relY)
+	}
+	return x, y
+}
+
 // RemoveFishEye try to remove the fish eye effect from the image.
 // The image still be loss some pixels at the center
 func RemoveFishEye(dest draw.Image, src image.Image, distance int) draw.Image {
+	if d, ok := dest.(*image.RGBA); ok {
+		if s, ok := src.(*image.RGBA); ok {
+			return removeFishEyeRGBA(d, s, distance)
+		}
+	}
 	b := src.Bounds()
 	midX, midY, radius := b.Dx()/2, b.Dy()/2, float64(distance)
 	for y := b.Min.Y; y < b.Max.Y; y++ {
-		for x, relY := b.Min.X, float64(y-midY); x < b.Max.X; x++ {
-			x2, y2, relX := x, y, float64(x-midX)
-			if r := math.Sqrt(relX*relX + relY*relY); r < radius {
-				tmp := formula(r/radius) * radius / r
-				x2, y2 = midX+(int)(tmp*relX), midY+(int)(tmp*relY)
-			}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			x2, y2 := fishEyeMap(x, y, midX, midY, radius)
 			dest.Set(x2, y2, src.At(x, y))
 		}
 	}
 	return dest
 }
 
-// FindDistance finds the distance of the fish eye effect.
-func FindDistance(src image.Image, testRowIndex int) (image.Image, int) {
-	results := make(map[int]image.Image)
-	b := src.Bounds()
-	dx := b.Dx()
-	score, distance := dx, 0
-	for d, maxDistance := distanceRange(dx); d < maxDistance; d++ {
-		results[d] = RemoveFishEye(image.NewRGBA(b), src, d)
-		if s := WhitePoints(results[d], testRowIndex); s < score {
-			score = s
-			distance = d
-		}
+// fishEyeMap returns where RemoveFishEye moves the pixel at x, y.
+func fishEyeMap(x, y, midX, midY int, radius float64) (int, int) {
+	relX, relY := float64(x-midX), float64(y-midY)
+	if r := math.Sqrt(relX*relX + relY*relY); r < radius {
+		tmp := formula(r/radius) * radius / r
+		return midX + (int)(tmp*relX), midY + (int)(tmp*relY)
 	}
-	return results[distance], distance
+	return x, y
 }
 
 // WhitePoints returns the number of white points at the y-axis.
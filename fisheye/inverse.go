@@ -0,0 +1,78 @@
+package fisheye
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"giautm.dev/captcha/sampling"
+)
+
+// UndistortFishEye removes the fish eye effect from the image by inverse
+// mapping: every pixel of dest is sampled from src, so unlike RemoveFishEye
+// the result has no holes.
+func UndistortFishEye(dest draw.Image, src image.Image, distance int, interp sampling.Interpolation) draw.Image {
+	if d, ok := dest.(*image.RGBA); ok {
+		return undistortRGBA(d, toRGBA(src), distance, interp)
+	}
+	rgba := undistortRGBA(image.NewRGBA(dest.Bounds()), toRGBA(src), distance, interp)
+	draw.Draw(dest, dest.Bounds(), rgba, rgba.Bounds().Min, draw.Src)
+	return dest
+}
+
+func undistortRGBA(dest, src *image.RGBA, distance int, interp sampling.Interpolation) *image.RGBA {
+	b := dest.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		undistortRow(dest.Pix[dest.PixOffset(b.Min.X, y):], src, distance, y, b.Min.X, b.Max.X, interp)
+	}
+	return dest
+}
+
+// undistortRow samples the pixels from x0 to x1 of row y into dst.
+func undistortRow(dst []uint8, src *image.RGBA, distance, y, x0, x1 int, interp sampling.Interpolation) {
+	b := src.Bounds()
+	midX, midY, radius := b.Dx()/2, b.Dy()/2, float64(distance)
+	for x := x0; x < x1; x++ {
+		sx, sy := fishEyeUnmap(x, y, midX, midY, radius)
+		i := (x - x0) * 4
+		sampling.Sample(dst[i:i+4], src, sx, sy, interp)
+	}
+}
+
+// fishEyeUnmap returns the source point RemoveFishEye moves to x, y.
+func fishEyeUnmap(x, y, midX, midY int, radius float64) (float64, float64) {
+	relX, relY := float64(x-midX), float64(y-midY)
+	r2 := math.Sqrt(relX*relX + relY*relY)
+	if r2 >= radius {
+		return float64(x), float64(y)
+	}
+	if r2 == 0 {
+		return float64(midX), float64(midY)
+	}
+	// RemoveFishEye moves a pixel at r to radius*formula(r/radius).
+	k := formulaInverse(r2/radius) * radius / r2
+	return float64(midX) + k*relX, float64(midY) + k*relY
+}
+
+// formulaInverse solves formula(s) = t for s in [0, 1].
+// formula is strictly increasing on [0, 1], with formula(1) = 1.
+func formulaInverse(t float64) float64 {
+	lo, hi, s := 0.0, 1.0, t
+	for range 20 {
+		f := formula(s) - t
+		if math.Abs(f) < 1e-9 {
+			break
+		}
+		if f > 0 {
+			hi = s
+		} else {
+			lo = s
+		}
+		// Newton step, falling back to bisection when it leaves the bracket.
+		s -= f / (-2.25*s*s + 3*s + 0.25)
+		if s <= lo || s >= hi {
+			s = (lo + hi) / 2
+		}
+	}
+	return s
+}
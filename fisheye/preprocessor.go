@@ -5,6 +5,8 @@ import (
 	"errors"
 	"image"
 	"os"
+
+	"giautm.dev/captcha/sampling"
 )
 
 var (
@@ -12,19 +14,39 @@ var (
 )
 
 type FisheyePreprocessor struct {
+	// TestRowIndex is the row scored to find the distance,
+	// AutoTestRow selects the rows from the image.
 	TestRowIndex int
+	// TestRows is the number of rows selected by AutoTestRow.
+	TestRows int
+	// CoarseStep is the step of the coarse distance search,
+	// 0 or 1 searches every distance.
+	CoarseStep int
+	// Workers is the number of goroutines evaluating the distances.
+	Workers int
+	// Mapping is the way the effect is removed, InverseMapping
+	// gives an image without holes.
+	Mapping Mapping
+	// Interpolation is the sampling of InverseMapping.
+	Interpolation sampling.Interpolation
+	// Criterion is the way the candidate distances are scored.
+	Criterion Criterion
 }
 
 func NewPreprocessor() *FisheyePreprocessor {
 	return &FisheyePreprocessor{
-		// We test pixels at line 42 to check correct distance
-		TestRowIndex: 42,
+		TestRowIndex: AutoTestRow,
+		TestRows:     3,
 	}
 }
 
 // Transform implements the Preprocessor interface.
 func (p *FisheyePreprocessor) Transform(_ context.Context, img image.Image) (image.Image, error) {
-	if result, distance := FindDistance(img, p.TestRowIndex); distance >= 0 {
+	result, distance := FindDistance(img, p.TestRowIndex,
+		WithCoarseStep(p.CoarseStep), WithWorkers(p.Workers),
+		WithMapping(p.Mapping, p.Interpolation), WithCriterion(p.Criterion),
+		WithAutoTestRows(p.TestRows))
+	if distance >= 0 {
 		return result, nil
 	}
 	return nil, ErrDetectDistance
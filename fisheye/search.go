@@ -0,0 +1,368 @@
+package fisheye
+
+import (
+	"image"
+	"sort"
+	"sync"
+
+	"giautm.dev/captcha/sampling"
+)
+
+type (
+	// SearchOption is a function that sets an option of FindDistance.
+	SearchOption func(*search)
+	// Mapping is the way the fish eye effect is removed.
+	Mapping int
+	// Criterion is the way a candidate distance is scored, from the
+	// test rows of the image with the effect removed, or from the whole
+	// image for Duplicates. Lower is better.
+	Criterion int
+	search    struct {
+		step      int
+		workers   int
+		mapping   Mapping
+		interp    sampling.Interpolation
+		criterion Criterion
+		autoRows  int
+	}
+)
+
+// AutoTestRow lets FindDistance select the test rows from the image content.
+const AutoTestRow = -1
+
+const (
+	// ForwardMapping removes the effect with RemoveFishEye.
+	ForwardMapping Mapping = iota
+	// InverseMapping removes the effect with UndistortFishEye.
+	InverseMapping
+)
+
+const (
+	// DefaultCriterion is HoleCount for ForwardMapping,
+	// and Duplicates for InverseMapping.
+	DefaultCriterion Criterion = iota
+	// HoleCount counts the transparent pixels left by ForwardMapping.
+	HoleCount
+	// Duplicates checks the pixels ApplyFishEye duplicates where it
+	// magnifies the center: the neighbour pixels sampled from the same
+	// source pixel must be equal. Ties go to the distance predicting
+	// the most duplicates. It scores the whole image, and needs ink
+	// near the center, a blank center scores every distance the same.
+	// Its score is not unimodal, so WithCoarseStep may miss the best
+	// distance.
+	Duplicates
+)
+
+// WithCoarseStep evaluates every step-th distance first, then refines
+// around the best one. It is faster, but may miss the best distance
+// if the score is not unimodal. The default is 1, an exhaustive search.
+func WithCoarseStep(step int) SearchOption {
+	return func(s *search) {
+		s.step = max(step, 1)
+	}
+}
+
+// WithMapping sets the way the effect is removed, and the interpolation
+// used by InverseMapping. The default is ForwardMapping.
+func WithMapping(m Mapping, interp sampling.Interpolation) SearchOption {
+	return func(s *search) {
+		s.mapping, s.interp = m, interp
+	}
+}
+
+// WithCriterion sets the way the candidate distances are scored.
+func WithCriterion(c Criterion) SearchOption {
+	return func(s *search) {
+		s.criterion = c
+	}
+}
+
+// WithAutoTestRows sets the number of rows selected by SelectTestRows,
+// when FindDistance is called with AutoTestRow. The scores of the rows
+// are summed. The default is 1.
+func WithAutoTestRows(n int) SearchOption {
+	return func(s *search) {
+		s.autoRows = max(n, 1)
+	}
+}
+
+// WithWorkers evaluates the candidate distances in n goroutines.
+// The default is 1.
+func WithWorkers(n int) SearchOption {
+	return func(s *search) {
+		s.workers = max(n, 1)
+	}
+}
+
+// FindDistance finds the distance of the fish eye effect, and returns
+// the image with the effect removed. It returns -1 if no distance leaves
+// a pixel at the test rows.
+//
+// The test row is selected from the image if testRowIndex is AutoTestRow.
+func FindDistance(src image.Image, testRowIndex int, opts ...SearchOption) (image.Image, int) {
+	s := &search{step: 1, workers: 1, autoRows: 1}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.criterion == DefaultCriterion {
+		s.criterion = HoleCount
+		if s.mapping == InverseMapping {
+			s.criterion = Duplicates
+		}
+	}
+	rgba := toRGBA(src)
+	rows := []int{testRowIndex}
+	if testRowIndex == AutoTestRow {
+		rows = SelectTestRows(rgba, s.autoRows)
+	}
+	minDistance, maxDistance := distanceRange(rgba.Bounds().Dx())
+	score := func(d int) int {
+		if s.criterion == Duplicates {
+			return duplicates(rgba, d)
+		}
+		sc := 0
+		for _, y := range rows {
+			sc += s.score(rgba, d, y)
+		}
+		return sc
+	}
+	candidates := make([]int, 0, maxDistance-minDistance)
+	for d := minDistance; d < maxDistance; d += s.step {
+		candidates = append(candidates, d)
+	}
+	distance, best := s.best(candidates, score)
+	if s.step > 1 && distance >= 0 {
+		candidates = candidates[:0]
+		for d := max(distance-s.step+1, minDistance); d < min(distance+s.step, maxDistance); d++ {
+			candidates = append(candidates, d)
+		}
+		if d, sc := s.best(candidates, score); sc < best {
+			distance, best = d, sc
+		}
+	}
+	if distance < 0 || (s.criterion == HoleCount && best >= rgba.Bounds().Dx()*len(rows)) {
+		return nil, -1
+	}
+	if s.mapping == InverseMapping {
+		return undistortRGBA(image.NewRGBA(rgba.Bounds()), rgba, distance, s.interp), distance
+	}
+	return removeFishEyeRGBA(image.NewRGBA(rgba.Bounds()), rgba, distance), distance
+}
+
+// score renders the row y with the effect of the distance removed,
+// and scores it with the criterion.
+func (s *search) score(src *image.RGBA, distance, y int) int {
+	b := src.Bounds()
+	if y < b.Min.Y || y >= b.Max.Y {
+		return b.Dx()
+	}
+	row := make([]uint8, b.Dx()*4)
+	if s.mapping == InverseMapping {
+		undistortRow(row, src, distance, y, b.Min.X, b.Max.X, s.interp)
+	} else {
+		removeFishEyeRow(row, src, distance, y)
+	}
+	return holes(row)
+}
+
+// SelectTestRows returns the n rows of the image with the most ink,
+// from the most to the least inked. Rows with the same ink are
+// ordered by their distance to the center.
+func SelectTestRows(img image.Image, n int) []int {
+	rgba := toRGBA(img)
+	b := rgba.Bounds()
+	type row struct{ y, ink int }
+	rows := make([]row, 0, b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		i := rgba.PixOffset(b.Min.X, y)
+		rows = append(rows, row{y: y, ink: inkPixels(rgba.Pix[i : i+b.Dx()*4])})
+	}
+	midY := b.Min.Y + b.Dy()/2
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].ink != rows[j].ink {
+			return rows[i].ink > rows[j].ink
+		}
+		return abs(rows[i].y-midY) < abs(rows[j].y-midY)
+	})
+	selected := make([]int, min(n, len(rows)))
+	for i := range selected {
+		selected[i] = rows[i].y
+	}
+	return selected
+}
+
+// best returns the first candidate with the lowest score, or -1 if
+// every candidate leaves the whole row empty.
+func (s *search) best(candidates []int, score func(int) int) (int, int) {
+	scores := make([]int, len(candidates))
+	if s.workers > 1 {
+		var wg sync.WaitGroup
+		next := make(chan int)
+		for range s.workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					scores[i] = score(candidates[i])
+				}
+			}()
+		}
+		for i := range candidates {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	} else {
+		for i, d := range candidates {
+			scores[i] = score(d)
+		}
+	}
+	distance, best := -1, -1
+	for i, sc := range scores {
+		if sc < best || best < 0 {
+			distance, best = candidates[i], sc
+		}
+	}
+	return distance, best
+}
+
+// removeFishEyeRow renders the row y of RemoveFishEye(src, distance)
+// into dst, without rendering the whole image.
+func removeFishEyeRow(dst []uint8, src *image.RGBA, distance, y int) {
+	b := src.Bounds()
+	midX, midY, radius := b.Dx()/2, b.Dy()/2, float64(distance)
+	// The effect only moves pixels towards the center, so only rows
+	// farther from the center, on the same side, can land on y. A pixel
+	// at radius*s moves by radius*0.75*s*(1-s)^2, at most radius/9,
+	// and one more for the truncation.
+	d := distance/9 + 1
+	fromY, toY := max(b.Min.Y, y-d), min(b.Max.Y, y+d+1)
+	if relY := y - midY; relY > 0 {
+		fromY = max(fromY, y)
+	} else if relY < 0 {
+		toY = min(toY, y+1)
+	}
+	// The later pixels overwrite the earlier ones, as in RemoveFishEye.
+	for sy := fromY; sy < toY; sy++ {
+		for sx := b.Min.X; sx < b.Max.X; sx++ {
+			x2, y2 := fishEyeMap(sx, sy, midX, midY, radius)
+			if y2 == y && x2 >= b.Min.X && x2 < b.Max.X {
+				i, j := src.PixOffset(sx, sy), (x2-b.Min.X)*4
+				copy(dst[j:j+4], src.Pix[i:i+4])
+			}
+		}
+	}
+}
+
+// holes returns the number of transparent pixels of the RGBA row.
+func holes(row []uint8) int {
+	n := 0
+	for i := 3; i < len(row); i += 4 {
+		if row[i] == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// duplicates scores the distance by the neighbour pixels ApplyFishEye
+// samples from the same source pixel: first the ones which differ,
+// then the fewest duplicates.
+func duplicates(src *image.RGBA, distance int) int {
+	b := src.Bounds()
+	midX, midY, radius := b.Dx()/2, b.Dy()/2, float64(distance)
+	// ApplyFishEye keeps the pixels outside the radius in place.
+	x0, x1 := max(midX-distance, b.Min.X), min(midX+distance+1, b.Max.X)
+	y0, y1 := max(midY-distance, b.Min.Y), min(midY+distance+1, b.Max.Y)
+	if x0 >= x1 || y0 >= y1 {
+		return 0
+	}
+	prev, cur := make([]image.Point, x1-x0), make([]image.Point, x1-x0)
+	differ, dups := 0, 0
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			cur[x-x0].X, cur[x-x0].Y = fishEyeSource(x, y, midX, midY, radius)
+		}
+		for i, p := range cur {
+			j := src.PixOffset(x0+i, y)
+			ink := isInk(src.Pix[j : j+4])
+			if i > 0 && p == cur[i-1] {
+				dups++
+				if ink != isInk(src.Pix[j-4:j]) {
+					differ++
+				}
+			}
+			if y > y0 && p == prev[i] {
+				dups++
+				if k := j - src.Stride; ink != isInk(src.Pix[k:k+4]) {
+					differ++
+				}
+			}
+		}
+		prev, cur = cur, prev
+	}
+	maxDups := 2 * (x1 - x0) * (y1 - y0)
+	return differ*(maxDups+1) + maxDups - dups
+}
+
+// inkPixels returns the number of ink pixels of the RGBA row.
+func inkPixels(row []uint8) int {
+	n := 0
+	for i := 0; i < len(row); i += 4 {
+		if isInk(row[i : i+4]) {
+			n++
+		}
+	}
+	return n
+}
+
+// isInk reports whether the premultiplied RGBA pixel is opaque
+// and darker than the middle gray.
+func isInk(p []uint8) bool {
+	lum := (299*int(p[0]) + 587*int(p[1]) + 114*int(p[2])) / 1000
+	return p[3] >= 0x80 && lum < int(p[3])/2
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// removeFishEyeRGBA is RemoveFishEye with direct access to the pixels.
+func removeFishEyeRGBA(dest, src *image.RGBA, distance int) *image.RGBA {
+	b, db := src.Bounds(), dest.Bounds()
+	midX, midY, radius := b.Dx()/2, b.Dy()/2, float64(distance)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			x2, y2 := fishEyeMap(x, y, midX, midY, radius)
+			if !(image.Point{x2, y2}.In(db)) {
+				continue
+			}
+			i, j := src.PixOffset(x, y), dest.PixOffset(x2, y2)
+			copy(dest.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dest
+}
+
+// toRGBA returns the image as *image.RGBA, converting it if needed.
+func toRGBA(src image.Image) *image.RGBA {
+	if rgba, ok := src.(*image.RGBA); ok {
+		return rgba
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := src.At(x, y).RGBA()
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r >> 8)
+			dst.Pix[i+1] = uint8(g >> 8)
+			dst.Pix[i+2] = uint8(bl >> 8)
+			dst.Pix[i+3] = uint8(a >> 8)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,168 @@
+package fisheye
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+
+	"giautm.dev/captcha/sampling"
+)
+
+// testCaptcha returns a 180x50 image of random dark strokes on a white
+// background, with the fish eye effect of the distance applied.
+func testCaptcha(seed int64, distance int) *image.RGBA {
+	r := rand.New(rand.NewSource(seed))
+	img := image.NewRGBA(image.Rect(0, 0, 180, 50))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for range 12 {
+		x, y := 10+r.Intn(160), 5+r.Intn(40)
+		dx, dy := r.Intn(3)-1, r.Intn(3)-1
+		for range 4 + r.Intn(12) {
+			draw.Draw(img, image.Rect(x, y, x+2, y+2), image.NewUniform(color.Black), image.Point{}, draw.Src)
+			x, y = x+dx, y+dy
+		}
+	}
+	return ApplyFishEye(image.NewRGBA(img.Bounds()), img, distance).(*image.RGBA)
+}
+
+// findDistanceFull is the search rendering the whole image with
+// RemoveFishEye for each candidate distance.
+func findDistanceFull(src image.Image, testRowIndex int) (image.Image, int) {
+	b := src.Bounds()
+	score, distance := b.Dx(), -1
+	var best image.Image
+	for d, maxDistance := distanceRange(b.Dx()); d < maxDistance; d++ {
+		img := RemoveFishEye(image.NewRGBA(b), src, d)
+		if s := WhitePoints(img, testRowIndex); s < score {
+			score, distance, best = s, d, img
+		}
+	}
+	return best, distance
+}
+
+func TestFindDistanceEquivalence(t *testing.T) {
+	tests := []struct {
+		name     string
+		seed     int64
+		distance int
+		row      int
+		opts     []SearchOption
+	}{
+		{"exhaustive top", 1, 50, 5, nil},
+		{"exhaustive center", 2, 55, 25, nil},
+		{"exhaustive bottom", 3, 60, 44, nil},
+		{"workers", 1, 50, 5, []SearchOption{WithWorkers(4)}},
+		{"outside", 4, 50, 50, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := testCaptcha(tt.seed, tt.distance)
+			want, wantDistance := findDistanceFull(src, tt.row)
+			got, distance := FindDistance(src, tt.row, tt.opts...)
+			if distance != wantDistance {
+				t.Fatalf("distance = %d, want %d", distance, wantDistance)
+			}
+			if distance < 0 {
+				if got != nil {
+					t.Errorf("image = %v, want nil", got.Bounds())
+				}
+				return
+			}
+			if !bytes.Equal(got.(*image.RGBA).Pix, want.(*image.RGBA).Pix) {
+				t.Errorf("pixels differ from RemoveFishEye at distance %d", distance)
+			}
+		})
+	}
+}
+
+// TestRemoveFishEyeRow checks the rows scanned by removeFishEyeRow
+// against the whole image rendered by RemoveFishEye.
+func TestRemoveFishEyeRow(t *testing.T) {
+	src := testCaptcha(1, 60)
+	b := src.Bounds()
+	minDistance, maxDistance := distanceRange(b.Dx())
+	for d := minDistance; d < maxDistance; d++ {
+		want := removeFishEyeRGBA(image.NewRGBA(b), src, d)
+		row := make([]uint8, b.Dx()*4)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			clear(row)
+			removeFishEyeRow(row, src, d, y)
+			if i := want.PixOffset(b.Min.X, y); !bytes.Equal(row, want.Pix[i:i+len(row)]) {
+				t.Fatalf("row %d at distance %d differs from RemoveFishEye", y, d)
+			}
+		}
+	}
+}
+
+func TestFindDistanceRecovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		seed     int64
+		distance int
+		opts     []SearchOption
+	}{
+		{"inverse 50", 1, 50, []SearchOption{WithMapping(InverseMapping, sampling.Bilinear)}},
+		{"inverse 55", 2, 55, []SearchOption{WithMapping(InverseMapping, sampling.Bilinear)}},
+		{"inverse 60", 3, 60, []SearchOption{WithMapping(InverseMapping, sampling.Nearest)}},
+		{"inverse smallest", 2, 45, []SearchOption{WithMapping(InverseMapping, sampling.Bilinear)}},
+		{"forward duplicates", 1, 50, []SearchOption{WithCriterion(Duplicates)}},
+		{"workers", 2, 55, []SearchOption{WithMapping(InverseMapping, sampling.Bilinear), WithWorkers(4)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]SearchOption{WithAutoTestRows(3)}, tt.opts...)
+			img, distance := FindDistance(testCaptcha(tt.seed, tt.distance), AutoTestRow, opts...)
+			if distance != tt.distance {
+				t.Errorf("distance = %d, want %d", distance, tt.distance)
+			}
+			if img == nil {
+				t.Error("image = nil, want the effect removed")
+			}
+		})
+	}
+}
+
+func TestPreprocessorInverseMapping(t *testing.T) {
+	src := testCaptcha(1, 50)
+	p := NewPreprocessor()
+	p.Mapping, p.Interpolation = InverseMapping, sampling.Bilinear
+	got, err := p.Transform(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UndistortFishEye(image.NewRGBA(src.Bounds()), src, 50, sampling.Bilinear).(*image.RGBA)
+	if !bytes.Equal(got.(*image.RGBA).Pix, want.Pix) {
+		t.Error("image differs from UndistortFishEye at the distance 50")
+	}
+}
+
+func BenchmarkFindDistance(b *testing.B) {
+	src := testCaptcha(1, 50)
+	benchmarks := []struct {
+		name string
+		opts []SearchOption
+	}{
+		{"exhaustive", nil},
+		{"coarse", []SearchOption{WithCoarseStep(4)}},
+		{"workers", []SearchOption{WithWorkers(4)}},
+	}
+	for _, row := range []int{5, 25, 44} {
+		b.Run(fmt.Sprintf("full/row=%d", row), func(b *testing.B) {
+			for range b.N {
+				findDistanceFull(src, row)
+			}
+		})
+		for _, bm := range benchmarks {
+			b.Run(fmt.Sprintf("%s/row=%d", bm.name, row), func(b *testing.B) {
+				for range b.N {
+					FindDistance(src, row, bm.opts...)
+				}
+			})
+		}
+	}
+}
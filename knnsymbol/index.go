@@ -0,0 +1,227 @@
+package knnsymbol
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+
+	// Register decoders for the labeled images.
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+type (
+	// Index is a nearest-neighbour index over labeled symbol images.
+	// Each image is stored as a bitset of its downsampled, binarized pixels.
+	//
+	// The images are the glyphs of single symbols, as cropped by
+	// segment.Splitter and written by cmd/label-imgs -segment.
+	Index struct {
+		Width   int
+		Height  int
+		Labels  []string
+		Samples []Sample
+	}
+	// Sample is a labeled feature vector of the index.
+	Sample struct {
+		Label    int
+		Features []uint64
+	}
+	// IndexOption is a function that sets an option on the Index.
+	IndexOption func(*Index) error
+)
+
+var (
+	ErrInvalidIndex = errors.New("knnsymbol: invalid index")
+)
+
+// WithFeatureSize sets the size images are downsampled to before
+// being binarized. The default is 16x16.
+func WithFeatureSize(width, height int) IndexOption {
+	return func(ix *Index) error {
+		if width < 1 || height < 1 {
+			return fmt.Errorf("knnsymbol: invalid feature size %dx%d", width, height)
+		}
+		ix.Width, ix.Height = width, height
+		return nil
+	}
+}
+
+// NewIndex creates an empty Index.
+func NewIndex(opts ...IndexOption) (*Index, error) {
+	ix := &Index{Width: 16, Height: 16}
+	for _, o := range opts {
+		if err := o(ix); err != nil {
+			return nil, err
+		}
+	}
+	return ix, nil
+}
+
+// BuildIndex creates an Index from the labeled directory tree,
+// where images are stored as <dir>/<label>/<name>, like the glyphs
+// written by cmd/label-imgs -segment.
+func BuildIndex(dir string, opts ...IndexOption) (*Index, error) {
+	ix, err := NewIndex(opts...)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, label := range labels {
+		if !label.IsDir() {
+			continue
+		}
+		files, err := os.ReadDir(filepath.Join(dir, label.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.IsDir() {
+				continue
+			}
+			img, err := decodeFile(filepath.Join(dir, label.Name(), f.Name()))
+			if err != nil {
+				return nil, err
+			}
+			ix.Add(label.Name(), img)
+		}
+	}
+	return ix, nil
+}
+
+// Add adds the image of the label to the index.
+func (ix *Index) Add(label string, img image.Image) {
+	id := -1
+	for i, l := range ix.Labels {
+		if l == label {
+			id = i
+			break
+		}
+	}
+	if id < 0 {
+		id = len(ix.Labels)
+		ix.Labels = append(ix.Labels, label)
+	}
+	ix.Samples = append(ix.Samples, Sample{
+		Label:    id,
+		Features: Features(img, ix.Width, ix.Height),
+	})
+}
+
+// Save writes the index to w.
+func (ix *Index) Save(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(ix)
+}
+
+// SaveFile writes the index to the file at path.
+func (ix *Index) SaveFile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = ix.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Load reads an index written by Save. It fails with ErrInvalidIndex
+// if the samples do not match the feature size or the labels.
+func Load(r io.Reader) (*Index, error) {
+	ix := &Index{}
+	if err := gob.NewDecoder(r).Decode(ix); err != nil {
+		return nil, err
+	}
+	if err := ix.validate(); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+// LoadFile reads an index written by SaveFile.
+func LoadFile(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Load(file)
+}
+
+// validate checks the feature size, and that every sample has
+// the features of that size and a known label.
+func (ix *Index) validate() error {
+	if ix.Width < 1 || ix.Height < 1 {
+		return fmt.Errorf("%w: feature size %dx%d", ErrInvalidIndex, ix.Width, ix.Height)
+	}
+	n := (ix.Width*ix.Height + 63) / 64
+	for i, s := range ix.Samples {
+		if len(s.Features) != n {
+			return fmt.Errorf("%w: sample %d has %d features, want %d", ErrInvalidIndex, i, len(s.Features), n)
+		}
+		if s.Label < 0 || s.Label >= len(ix.Labels) {
+			return fmt.Errorf("%w: sample %d has unknown label %d", ErrInvalidIndex, i, s.Label)
+		}
+	}
+	return nil
+}
+
+// Features downsamples the image to width x height by averaging,
+// and binarizes it against its mean luminance. Pixels darker than
+// the mean are set in the returned bitset.
+func Features(img image.Image, width, height int) []uint64 {
+	b := img.Bounds()
+	lum := make([]float64, width*height)
+	counts := make([]int, width*height)
+	var total float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		fy := (y - b.Min.Y) * height / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			fx := (x - b.Min.X) * width / b.Dx()
+			l := float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+			lum[fy*width+fx] += l
+			counts[fy*width+fx]++
+			total += l
+		}
+	}
+	mean := total / float64(max(b.Dx()*b.Dy(), 1))
+	features := make([]uint64, (width*height+63)/64)
+	for i, l := range lum {
+		if counts[i] > 0 && l/float64(counts[i]) < mean {
+			features[i/64] |= 1 << (i % 64)
+		}
+	}
+	return features
+}
+
+// distance returns the Hamming distance between two feature bitsets.
+func distance(a, b []uint64) int {
+	d := 0
+	for i := range a {
+		d += bits.OnesCount64(a[i] ^ b[i])
+	}
+	return d
+}
+
+func decodeFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("knnsymbol: decode %s: %w", path, err)
+	}
+	return img, nil
+}
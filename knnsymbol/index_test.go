@@ -0,0 +1,129 @@
+package knnsymbol
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// row returns an 8x1 image, the pixel x is dark if the bit x is set.
+func row(bits uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, 8, 1))
+	for x := range 8 {
+		img.SetGray(x, 0, color.Gray{Y: 0xff})
+		if bits&(1<<x) != 0 {
+			img.SetGray(x, 0, color.Gray{})
+		}
+	}
+	return img
+}
+
+func TestFeatures(t *testing.T) {
+	tests := []struct {
+		name          string
+		img           image.Image
+		width, height int
+		want          []uint64
+	}{
+		{"same size", row(0b00001111), 8, 1, []uint64{0b00001111}},
+		{"downsampled", row(0b11110000), 2, 1, []uint64{0b10}},
+		{"blank", row(0), 8, 1, []uint64{0}},
+		{"two words", image.NewGray(image.Rect(0, 0, 10, 10)), 10, 10, []uint64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Features(tt.img, tt.width, tt.height); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Features = %b, want %b", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndexSaveLoad(t *testing.T) {
+	ix, err := NewIndex(WithFeatureSize(8, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ix.Add("a", row(0b00001111))
+	ix.Add("b", row(0b11110000))
+	ix.Add("a", row(0b00011111))
+	var buf bytes.Buffer
+	if err := ix.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ix) {
+		t.Errorf("Load = %+v, want %+v", got, ix)
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	dir := t.TempDir()
+	for name, bits := range map[string]uint8{"a/0-x.png": 0b1111, "a/1-x.png": 0b11111, "b/0-y.png": 0b11110000} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, row(bits)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix, err := BuildIndex(dir, WithFeatureSize(8, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b"}; !reflect.DeepEqual(ix.Labels, want) {
+		t.Errorf("labels = %v, want %v", ix.Labels, want)
+	}
+	if len(ix.Samples) != 3 {
+		t.Errorf("%d samples, want 3", len(ix.Samples))
+	}
+}
+
+func TestIndexValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		ix   Index
+		ok   bool
+	}{
+		{"valid", Index{Width: 8, Height: 1, Labels: []string{"a"}, Samples: []Sample{{Label: 0, Features: []uint64{1}}}}, true},
+		{"empty", Index{Width: 8, Height: 1}, true},
+		{"zero size", Index{Width: 0, Height: 1}, false},
+		{"negative size", Index{Width: 8, Height: -1}, false},
+		{"short features", Index{Width: 8, Height: 8, Labels: []string{"a"}, Samples: []Sample{{Label: 0, Features: nil}}}, false},
+		{"long features", Index{Width: 8, Height: 1, Labels: []string{"a"}, Samples: []Sample{{Label: 0, Features: []uint64{1, 2}}}}, false},
+		{"unknown label", Index{Width: 8, Height: 1, Labels: []string{"a"}, Samples: []Sample{{Label: 1, Features: []uint64{1}}}}, false},
+		{"negative label", Index{Width: 8, Height: 1, Labels: []string{"a"}, Samples: []Sample{{Label: -1, Features: []uint64{1}}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.ix.validate()
+			if tt.ok && err != nil {
+				t.Errorf("validate: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidIndex) {
+				t.Errorf("validate error = %v, want %v", err, ErrInvalidIndex)
+			}
+			var buf bytes.Buffer
+			if err := tt.ix.Save(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := Load(&buf); !tt.ok && !errors.Is(err, ErrInvalidIndex) {
+				t.Errorf("Load error = %v, want %v", err, ErrInvalidIndex)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package knnsymbol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+
+	"giautm.dev/captcha/engine"
+)
+
+type (
+	// Resolver is a struct that resolves the symbol of the image
+	// by the k-nearest-neighbours of the Index, without any external service.
+	// The images are glyphs of single symbols, see Index.
+	Resolver struct {
+		index *Index
+		k     int
+		topK  int
+	}
+	// Option is a function that sets an option on the Resolver.
+	Option func(*Resolver) error
+)
+
+var (
+	ErrEmptyIndex = errors.New("knnsymbol: index is empty")
+)
+
+// WithNeighbours sets the number of nearest neighbours that vote
+// for the symbol. The default is 5.
+func WithNeighbours(k int) Option {
+	return func(r *Resolver) error {
+		if k < 1 {
+			return fmt.Errorf("knnsymbol: neighbours must be positive")
+		}
+		r.k = k
+		return nil
+	}
+}
+
+// WithTopK sets the number of candidates, including the best match,
+// reported by SymbolResolveScored. The default is 3.
+func WithTopK(k int) Option {
+	return func(r *Resolver) error {
+		if k < 1 {
+			return fmt.Errorf("knnsymbol: top-k must be positive")
+		}
+		r.topK = k
+		return nil
+	}
+}
+
+// NewResolver creates a new Resolver over the index. It fails with
+// ErrInvalidIndex if the samples do not match the feature size or the labels.
+func NewResolver(index *Index, opts ...Option) (*Resolver, error) {
+	if index == nil || len(index.Samples) == 0 {
+		return nil, ErrEmptyIndex
+	}
+	if err := index.validate(); err != nil {
+		return nil, err
+	}
+	r := &Resolver{index: index, k: 5, topK: 3}
+	for _, o := range opts {
+		if err := o(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// SymbolResolve resolves the symbol of the image.
+func (r *Resolver) SymbolResolve(ctx context.Context, img image.Image) (string, error) {
+	result, err := r.SymbolResolveScored(ctx, img)
+	if err != nil {
+		return "", err
+	}
+	return result.Symbol, nil
+}
+
+// SymbolResolveScored resolves the symbol of the image. The probability of
+// a candidate is its share of the distance-weighted votes of the neighbours.
+//
+// The candidates are restricted to the symbols allowed by
+// engine.SymbolFilterFromContext, unless none of them is.
+func (r *Resolver) SymbolResolveScored(ctx context.Context, img image.Image) (*engine.SymbolResult, error) {
+	ix := r.index
+	features := Features(img, ix.Width, ix.Height)
+	type neighbour struct{ label, dist int }
+	nearest := make([]neighbour, 0, r.k+1)
+	for _, s := range ix.Samples {
+		d := distance(features, s.Features)
+		if len(nearest) == r.k && d >= nearest[r.k-1].dist {
+			continue
+		}
+		i := sort.Search(len(nearest), func(i int) bool { return nearest[i].dist > d })
+		nearest = append(nearest, neighbour{})
+		copy(nearest[i+1:], nearest[i:])
+		nearest[i] = neighbour{label: s.Label, dist: d}
+		if len(nearest) > r.k {
+			nearest = nearest[:r.k]
+		}
+	}
+	votes := make([]float32, len(ix.Labels))
+	var total float32
+	for _, n := range nearest {
+		w := 1 / float32(1+n.dist)
+		votes[n.label] += w
+		total += w
+	}
+	candidates := make([]engine.SymbolCandidate, 0, len(votes))
+	for label, v := range votes {
+		if v > 0 {
+			candidates = append(candidates, engine.SymbolCandidate{
+				Symbol:      ix.Labels[label],
+				Probability: v / total,
+			})
+		}
+	}
+	if allowed := engine.SymbolFilterFromContext(ctx); allowed != nil {
+		filtered := make([]engine.SymbolCandidate, 0, len(candidates))
+		for _, c := range candidates {
+			if allowed(c.Symbol) {
+				filtered = append(filtered, c)
+			}
+		}
+		if len(filtered) > 0 {
+			candidates = filtered
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Probability > candidates[j].Probability
+	})
+	candidates = candidates[:min(r.topK, len(candidates))]
+	return &engine.SymbolResult{
+		Symbol:       candidates[0].Symbol,
+		Probability:  candidates[0].Probability,
+		Alternatives: candidates[1:],
+	}, nil
+}
@@ -0,0 +1,82 @@
+package knnsymbol
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"giautm.dev/captcha/engine"
+)
+
+// testIndex returns an index of 8x1 features, at the distances
+// 0, 1, 1 and 8 of the query row(0b00001111).
+func testIndex() *Index {
+	return &Index{
+		Width:  8,
+		Height: 1,
+		Labels: []string{"a", "b"},
+		Samples: []Sample{
+			{Label: 1, Features: []uint64{0b11110000}},
+			{Label: 0, Features: []uint64{0b00001111}},
+			{Label: 0, Features: []uint64{0b00011111}},
+			{Label: 1, Features: []uint64{0b00000111}},
+		},
+	}
+}
+
+func TestResolverVote(t *testing.T) {
+	tests := []struct {
+		name  string
+		k     int
+		allow engine.SymbolFilter
+		want  []engine.SymbolCandidate
+	}{
+		// The weight of a neighbour is 1/(1+distance).
+		{"nearest", 1, nil, []engine.SymbolCandidate{{Symbol: "a", Probability: 1}}},
+		{"weighted", 3, nil, []engine.SymbolCandidate{{Symbol: "a", Probability: 0.75}, {Symbol: "b", Probability: 0.25}}},
+		{"all", 4, nil, []engine.SymbolCandidate{
+			{Symbol: "a", Probability: 1.5 / (2 + 1.0/9)},
+			{Symbol: "b", Probability: (0.5 + 1.0/9) / (2 + 1.0/9)},
+		}},
+		{"filtered", 3, engine.Charset("b"), []engine.SymbolCandidate{{Symbol: "b", Probability: 0.25}}},
+		{"filtered out", 1, engine.Charset("b"), []engine.SymbolCandidate{{Symbol: "a", Probability: 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewResolver(testIndex(), WithNeighbours(tt.k))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			if tt.allow != nil {
+				ctx = engine.WithSymbolFilter(ctx, tt.allow)
+			}
+			result, err := r.SymbolResolveScored(ctx, row(0b00001111))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := append([]engine.SymbolCandidate{{Symbol: result.Symbol, Probability: result.Probability}}, result.Alternatives...)
+			if len(got) != len(tt.want) {
+				t.Fatalf("candidates = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Symbol != tt.want[i].Symbol || math.Abs(float64(got[i].Probability-tt.want[i].Probability)) > 1e-6 {
+					t.Errorf("candidates = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestNewResolverInvalid(t *testing.T) {
+	if _, err := NewResolver(&Index{Width: 8, Height: 1}); !errors.Is(err, ErrEmptyIndex) {
+		t.Errorf("NewResolver error = %v, want %v", err, ErrEmptyIndex)
+	}
+	ix := testIndex()
+	ix.Samples[2].Features = []uint64{1, 2}
+	if _, err := NewResolver(ix); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("NewResolver error = %v, want %v", err, ErrInvalidIndex)
+	}
+}
@@ -6,10 +6,30 @@ import (
 	"strings"
 )
 
+const (
+	// DefaultBlank is the label of the positions past the end of
+	// captchas shorter than the encoded positions.
+	DefaultBlank = "_"
+	// DefaultPositions is the number of positions encoded per captcha,
+	// for training and resolving alike.
+	DefaultPositions = 5
+)
+
 func CaptchaFromName(name string) string {
 	return strings.SplitN(name, "--", 2)[0]
 }
 
+// SymbolAt returns the symbol at the position of the captcha,
+// or the blank label past its end.
+func SymbolAt(captcha string, pos int, blank string) string {
+	for i, r := range []rune(captcha) {
+		if i == pos {
+			return string(r)
+		}
+	}
+	return blank
+}
+
 func CreateLabeledFile(dir, label, name string) (*os.File, error) {
 	labelDir := filepath.Join(dir, label)
 	if err := os.MkdirAll(labelDir, os.ModePerm); err != nil {
@@ -0,0 +1,36 @@
+package labeled
+
+import "testing"
+
+func TestSymbolAt(t *testing.T) {
+	tests := []struct {
+		captcha string
+		pos     int
+		want    string
+	}{
+		{"abc", 0, "a"},
+		{"abc", 2, "c"},
+		{"abc", 3, DefaultBlank},
+		{"", 0, DefaultBlank},
+		{"đắk", 1, "ắ"},
+		{"đắk", 3, DefaultBlank},
+	}
+	for _, tt := range tests {
+		if got := SymbolAt(tt.captcha, tt.pos, DefaultBlank); got != tt.want {
+			t.Errorf("SymbolAt(%q, %d) = %q, want %q", tt.captcha, tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestCaptchaFromName(t *testing.T) {
+	tests := []struct{ name, want string }{
+		{"abcde--1700000000.png", "abcde"},
+		{"abcde", "abcde"},
+		{"ab-cd--1--2.png", "ab-cd"},
+	}
+	for _, tt := range tests {
+		if got := CaptchaFromName(tt.name); got != tt.want {
+			t.Errorf("CaptchaFromName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
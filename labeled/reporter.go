@@ -0,0 +1,95 @@
+package labeled
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"giautm.dev/captcha/engine"
+)
+
+// Reporter is a ResultReporter that saves the captcha images with their
+// predicted text as <captcha>--<suffix>, so they can be relabeled.
+type Reporter struct {
+	// CorrectDir is where correct captchas are saved, empty to skip them.
+	CorrectDir string
+	// IncorrectDir is where incorrect captchas are saved for cmd/relabel.
+	IncorrectDir string
+}
+
+var (
+	ErrNoSource = errors.New("labeled: result has no source image")
+	// ErrInvalidCaptcha is returned for captchas which can not be
+	// a file name: empty, or with a path separator, ".." or a NUL byte.
+	ErrInvalidCaptcha = errors.New("labeled: captcha is not a valid file name")
+)
+
+// NewReporter creates a Reporter saving into the correct and
+// incorrect directories of the data directory.
+func NewReporter(dataDir string) *Reporter {
+	return &Reporter{
+		CorrectDir:   filepath.Join(dataDir, "correct"),
+		IncorrectDir: filepath.Join(dataDir, "incorrect"),
+	}
+}
+
+// Report implements the ResultReporter interface.
+func (r *Reporter) Report(_ context.Context, result *engine.CaptchaResult, correct bool) error {
+	if err := r.ValidateReport(result, correct); err != nil {
+		return err
+	}
+	dir := r.dir(correct)
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s--%d%s", result.Captcha, time.Now().UnixNano(), sourceExt(result.Source))
+	return os.WriteFile(filepath.Join(dir, name), result.Source, 0o644)
+}
+
+// ValidateReport implements the engine.ReportValidator interface,
+// a saved result needs a valid captcha and its Source.
+func (r *Reporter) ValidateReport(result *engine.CaptchaResult, correct bool) error {
+	switch {
+	case r.dir(correct) == "":
+		return nil
+	case !validName(result.Captcha):
+		return ErrInvalidCaptcha
+	case len(result.Source) == 0:
+		return ErrNoSource
+	}
+	return nil
+}
+
+func (r *Reporter) dir(correct bool) string {
+	if correct {
+		return r.CorrectDir
+	}
+	return r.IncorrectDir
+}
+
+func validName(captcha string) bool {
+	return captcha != "" &&
+		!strings.ContainsAny(captcha, `/\`+"\x00") &&
+		!strings.Contains(captcha, "..") &&
+		filepath.Base(captcha) == captcha
+}
+
+func sourceExt(source []byte) string {
+	switch http.DetectContentType(source) {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/gif":
+		return ".gif"
+	}
+	return ""
+}
@@ -0,0 +1,198 @@
+package preprocess
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// LineRemover is a Preprocessor that erases thin crossing lines and
+// isolated dots, keeping the character strokes. Ink is found with the
+// Otsu threshold, erased pixels are painted white.
+//
+// A pixel belongs to a line by its vertical run of ink, so only the
+// lines closer to horizontal are erased: a line of thickness t at the
+// angle a has vertical runs of t/cos(a), and is kept once they exceed
+// MaxLineWidth, like a 1 pixel line steeper than 60 degrees with the
+// defaults. Steeper lines could not be told from the character strokes.
+type LineRemover struct {
+	// MaxLineWidth is the maximum vertical thickness of a line.
+	MaxLineWidth int
+	// MinLineLength is the minimum horizontal extent of a line,
+	// shorter thin strokes are kept as parts of the characters.
+	MinLineLength int
+	// MaxCrossing is the widest character stroke a line can cross
+	// and still be joined with its other side.
+	MaxCrossing int
+	// MaxDotArea is the maximum area of an isolated dot.
+	MaxDotArea int
+}
+
+// NewLineRemover creates a LineRemover for lines up to 2 pixels thick
+// and 20 pixels long crossing strokes up to 8 pixels, and dots up to 4 pixels.
+func NewLineRemover() *LineRemover {
+	return &LineRemover{
+		MaxLineWidth:  2,
+		MinLineLength: 20,
+		MaxCrossing:   8,
+		MaxDotArea:    4,
+	}
+}
+
+// Transform implements the Preprocessor interface.
+func (r *LineRemover) Transform(_ context.Context, img image.Image) (image.Image, error) {
+	g := toGray(img)
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	t := OtsuThreshold(g)
+	ink := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ink[y*w+x] = g.Pix[y*g.Stride+x] < t
+		}
+	}
+	erase := make([]bool, w*h)
+	// A line is a component of thin pixels, those with a short vertical
+	// run of ink, spanning enough columns. The character strokes crossing
+	// it have long vertical runs, so they are kept.
+	thin := make([]bool, w*h)
+	for x := 0; x < w; x++ {
+		for y := 0; y < h; {
+			if !ink[y*w+x] {
+				y++
+				continue
+			}
+			end := y
+			for end < h && ink[end*w+x] {
+				end++
+			}
+			if end-y <= r.MaxLineWidth {
+				for ; y < end; y++ {
+					thin[y*w+x] = true
+				}
+			}
+			y = end
+		}
+	}
+	for _, c := range r.joinCrossings(components(thin, w, h), thin, ink, w, h) {
+		if c.bounds.Dx() >= r.MinLineLength {
+			for _, i := range c.pixels {
+				erase[i], ink[i] = true, false
+			}
+		}
+	}
+	for _, c := range components(ink, w, h) {
+		if len(c.pixels) <= r.MaxDotArea {
+			for _, i := range c.pixels {
+				erase[i] = true
+			}
+		}
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	for i, e := range erase {
+		if e {
+			dst.SetRGBA(i%w, i/w, color.RGBA{0xff, 0xff, 0xff, 0xff})
+		}
+	}
+	return dst, nil
+}
+
+// joinCrossings merges the thin components split by a character stroke,
+// that is those joined by a horizontal run of thick ink of at most
+// MaxCrossing pixels.
+func (r *LineRemover) joinCrossings(cs []component, thin, ink []bool, w, h int) []component {
+	label := make([]int, w*h)
+	parent := make([]int, len(cs))
+	for id, c := range cs {
+		parent[id] = id
+		for _, i := range c.pixels {
+			label[i] = id
+		}
+	}
+	var find func(int) int
+	find = func(id int) int {
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	for id, c := range cs {
+		for _, i := range c.pixels {
+			x, y := i%w, i/w
+			end := x + 1
+			for end < w && end-x <= r.MaxCrossing && ink[y*w+end] && !thin[y*w+end] {
+				end++
+			}
+			if end == x+1 || end >= w || end-x > r.MaxCrossing {
+				continue
+			}
+			for ny := max(y-1, 0); ny <= min(y+1, h-1); ny++ {
+				if j := ny*w + end; thin[j] {
+					parent[find(label[j])] = find(id)
+				}
+			}
+		}
+	}
+	merged := map[int]*component{}
+	for id, c := range cs {
+		root := find(id)
+		m, ok := merged[root]
+		if !ok {
+			m = &component{}
+			merged[root] = m
+		}
+		m.pixels = append(m.pixels, c.pixels...)
+		m.bounds = m.bounds.Union(c.bounds)
+	}
+	result := make([]component, 0, len(merged))
+	for _, m := range merged {
+		result = append(result, *m)
+	}
+	return result
+}
+
+// component is an 8-connected set of pixels of a mask.
+type component struct {
+	pixels []int
+	bounds image.Rectangle
+}
+
+// components returns the 8-connected components of the w x h mask.
+func components(mask []bool, w, h int) []component {
+	var (
+		result  []component
+		visited = make([]bool, len(mask))
+		stack   []int
+	)
+	for start, set := range mask {
+		if !set || visited[start] {
+			continue
+		}
+		c := component{}
+		visited[start] = true
+		stack = append(stack[:0], start)
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%w, i/w
+			c.pixels = append(c.pixels, i)
+			c.bounds = c.bounds.Union(image.Rect(x, y, x+1, y+1))
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= w || ny >= h {
+						continue
+					}
+					if j := ny*w + nx; mask[j] && !visited[j] {
+						visited[j] = true
+						stack = append(stack, j)
+					}
+				}
+			}
+		}
+		result = append(result, c)
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+package preprocess
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// canvas returns a white 60x20 image with the dark rectangles.
+func canvas(rects ...image.Rectangle) *image.Gray {
+	g := image.NewGray(image.Rect(0, 0, 60, 20))
+	draw.Draw(g, g.Bounds(), image.White, image.Point{}, draw.Src)
+	for _, r := range rects {
+		draw.Draw(g, r, image.Black, image.Point{}, draw.Src)
+	}
+	return g
+}
+
+// line draws a 1 pixel line from x0, y0 to x1, y1.
+func line(g *image.Gray, x0, y0, x1, y1 int) *image.Gray {
+	n := max(abs(x1-x0), abs(y1-y0))
+	for i := 0; i <= n; i++ {
+		x, y := x0+(x1-x0)*i/n, y0+(y1-y0)*i/n
+		g.SetGray(x, y, color.Gray{})
+	}
+	return g
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// inkIn returns the number of dark pixels of img in r.
+func inkIn(img image.Image, r image.Rectangle) int {
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y < 0x80 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestLineRemover(t *testing.T) {
+	stroke := image.Rect(26, 2, 32, 18)
+	tests := []struct {
+		name string
+		img  *image.Gray
+		// kept is the area whose ink is kept, the rest is erased.
+		kept image.Rectangle
+		// minLength is the MinLineLength, 0 for the default.
+		minLength int
+	}{
+		{"horizontal line", line(canvas(), 0, 10, 59, 10), image.Rectangle{}, 0},
+		{"thick line", canvas(image.Rect(0, 9, 60, 11)), image.Rectangle{}, 0},
+		{"slanted line", line(canvas(), 0, 2, 59, 17), image.Rectangle{}, 0},
+		{"line crossing a stroke", line(canvas(stroke), 0, 10, 59, 12), stroke, 0},
+		{"short stroke", canvas(image.Rect(10, 10, 25, 12)), image.Rect(10, 10, 25, 12), 0},
+		{"dot", canvas(image.Rect(10, 10, 12, 12)), image.Rectangle{}, 0},
+		{"blob", canvas(image.Rect(10, 10, 13, 13)), image.Rect(10, 10, 13, 13), 0},
+		{"vertical stroke", canvas(image.Rect(10, 0, 12, 20)), image.Rect(10, 0, 12, 20), 5},
+		{"52 degrees line", line(canvas(), 20, 0, 35, 19), image.Rectangle{}, 5},
+		// The vertical runs of a line steeper than 60 degrees are
+		// longer than MaxLineWidth, it is kept like a stroke.
+		{"72 degrees line", line(canvas(), 20, 0, 26, 19), image.Rect(20, 0, 27, 20), 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewLineRemover()
+			if tt.minLength > 0 {
+				r.MinLineLength = tt.minLength
+			}
+			got, err := r.Transform(context.Background(), tt.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, want := inkIn(got, tt.kept), inkIn(tt.img, tt.kept); n != want {
+				t.Errorf("%d ink pixels kept in %v, want %d", n, tt.kept, want)
+			}
+			if n, all := inkIn(got, got.Bounds()), inkIn(got, tt.kept); n != all {
+				t.Errorf("%d ink pixels left outside %v, want 0", n-all, tt.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package preprocess
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"giautm.dev/captcha/sampling"
+)
+
+// Deskew is a Preprocessor that estimates the dominant angle of the text
+// with its horizontal projection profile, and rotates the image back.
+// The area uncovered by the rotation is painted white.
+type Deskew struct {
+	// MaxAngle is the largest angle tried, in degrees, in both directions.
+	MaxAngle float64
+	// Step is the angle between the tried angles, in degrees.
+	Step float64
+	// Interpolation is the sampling of the rotated image.
+	Interpolation sampling.Interpolation
+}
+
+// NewDeskew creates a Deskew trying angles up to 15 degrees,
+// every half degree, with bilinear interpolation.
+func NewDeskew() *Deskew {
+	return &Deskew{
+		MaxAngle:      15,
+		Step:          0.5,
+		Interpolation: sampling.Bilinear,
+	}
+}
+
+// Transform implements the Preprocessor interface.
+func (d *Deskew) Transform(_ context.Context, img image.Image) (image.Image, error) {
+	src := toRGBA(img)
+	angle := d.Angle(src)
+	if angle == 0 {
+		return src, nil
+	}
+	return rotate(src, angle, d.Interpolation), nil
+}
+
+// Angle returns the angle of the text, in degrees, clockwise in the image.
+// The best angle makes the rows of ink the most contrasted, that is the
+// sum of the squared ink counts of the rows is the highest.
+func (d *Deskew) Angle(img image.Image) float64 {
+	g := toGray(img)
+	t := OtsuThreshold(g)
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	cx, cy := float64(w)/2, float64(h)/2
+	var xs, ys []float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if g.Pix[y*g.Stride+x] < t {
+				xs, ys = append(xs, float64(x)-cx), append(ys, float64(y)-cy)
+			}
+		}
+	}
+	if len(xs) == 0 || d.Step <= 0 {
+		return 0
+	}
+	diag := int(math.Hypot(float64(w), float64(h))) + 2
+	profile := make([]int, 2*diag)
+	best, bestScore := 0.0, -1
+	for a := 0.0; a <= d.MaxAngle; a += d.Step {
+		for _, angle := range []float64{a, -a} {
+			sin, cos := math.Sincos(angle * math.Pi / 180)
+			clear(profile)
+			// Floor, as truncation would make the row 0 twice as high.
+			for i := range xs {
+				profile[int(math.Floor(ys[i]*cos-xs[i]*sin))+diag]++
+			}
+			score := 0
+			for _, n := range profile {
+				score += n * n
+			}
+			if score > bestScore {
+				best, bestScore = angle, score
+			}
+			if a == 0 {
+				break
+			}
+		}
+	}
+	return best
+}
+
+// rotate rotates the image around its center, so that a text at the
+// angle, in degrees, becomes horizontal.
+func rotate(src *image.RGBA, angle float64, interp sampling.Interpolation) *image.RGBA {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	cx, cy := float64(w)/2, float64(h)/2
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	dst := image.NewRGBA(src.Rect)
+	for v := 0; v < h; v++ {
+		for u := 0; u < w; u++ {
+			du, dv := float64(u)+0.5-cx, float64(v)+0.5-cy
+			x := du*cos - dv*sin + cx - 0.5
+			y := du*sin + dv*cos + cy - 0.5
+			i := dst.PixOffset(u, v)
+			if x < -0.5 || y < -0.5 || x > float64(w)-0.5 || y > float64(h)-0.5 {
+				copy(dst.Pix[i:i+4], []uint8{0xff, 0xff, 0xff, 0xff})
+				continue
+			}
+			sampling.Sample(dst.Pix[i:i+4], src, x, y, interp)
+		}
+	}
+	return dst
+}
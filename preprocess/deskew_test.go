@@ -0,0 +1,77 @@
+package preprocess
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+
+	"giautm.dev/captcha/sampling"
+)
+
+// text returns a white 120x40 image with dark horizontal strokes,
+// like a line of text.
+func text() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 120, 40))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	for x := 15; x < 105; x += 10 {
+		draw.Draw(img, image.Rect(x, 16, x+7, 24), image.NewUniform(color.Black), image.Point{}, draw.Src)
+	}
+	return img
+}
+
+// inkRows returns the number of rows with ink.
+func inkRows(img image.Image) int {
+	n := 0
+	for _, row := range rows(img) {
+		for i := range len(row) {
+			if row[i] == '#' {
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+func TestDeskew(t *testing.T) {
+	tests := []struct {
+		name  string
+		skew  float64
+		want  float64
+		limit float64
+	}{
+		{"flat", 0, 0, 15},
+		{"clockwise", 8, 8, 15},
+		{"counterclockwise", -5, -5, 15},
+		{"half degree", 2.5, 2.5, 15},
+		{"beyond the limit", 10, 5, 5},
+	}
+	flat := text()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// rotate makes a text at the angle horizontal,
+			// so the opposite angle skews the flat text.
+			skewed := rotate(flat, -tt.skew, sampling.Bilinear)
+			d := NewDeskew()
+			d.MaxAngle = tt.limit
+			// The profile of the 8 pixels high text is about as contrasted
+			// within a degree of its skew.
+			if got := d.Angle(skewed); math.Abs(got-tt.want) > 1 {
+				t.Errorf("Angle = %v, want %v", got, tt.want)
+			}
+			if math.Abs(tt.skew) > tt.limit {
+				return
+			}
+			got, err := d.Transform(context.Background(), skewed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, want := inkRows(got), inkRows(flat); n > want+2 {
+				t.Errorf("deskewed text spans %d rows, want about %d", n, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package preprocess
+
+import (
+	"image"
+	"image/draw"
+	"slices"
+
+	"giautm.dev/captcha/engine"
+	"giautm.dev/captcha/sampling"
+)
+
+// Grayscale converts the image to grayscale.
+func Grayscale() engine.Preprocessor {
+	return filter(func(img image.Image) image.Image {
+		return toGray(img)
+	})
+}
+
+// Invert inverts the colors of the image, keeping its alpha.
+func Invert() engine.Preprocessor {
+	return filter(func(img image.Image) image.Image {
+		if g, ok := img.(*image.Gray); ok {
+			dst := image.NewGray(image.Rect(0, 0, g.Rect.Dx(), g.Rect.Dy()))
+			draw.Draw(dst, dst.Bounds(), g, g.Rect.Min, draw.Src)
+			for i, v := range dst.Pix {
+				dst.Pix[i] = 0xff - v
+			}
+			return dst
+		}
+		src := toRGBA(img)
+		dst := image.NewRGBA(src.Rect)
+		for i := 0; i < len(src.Pix); i += 4 {
+			// Premultiplied, so invert within the alpha.
+			a := src.Pix[i+3]
+			dst.Pix[i+0] = a - src.Pix[i+0]
+			dst.Pix[i+1] = a - src.Pix[i+1]
+			dst.Pix[i+2] = a - src.Pix[i+2]
+			dst.Pix[i+3] = a
+		}
+		return dst
+	})
+}
+
+// Otsu binarizes the grayscale image with the threshold that
+// minimizes the intra-class variance of its histogram.
+func Otsu() engine.Preprocessor {
+	return filter(func(img image.Image) image.Image {
+		g := toGray(img)
+		return threshold(g, OtsuThreshold(g))
+	})
+}
+
+// OtsuThreshold returns the Otsu threshold of the grayscale image,
+// pixels below it are foreground.
+func OtsuThreshold(g *image.Gray) uint8 {
+	var hist [256]int
+	for _, v := range g.Pix {
+		hist[v]++
+	}
+	total, sum := len(g.Pix), 0
+	for v, n := range hist {
+		sum += v * n
+	}
+	var (
+		best        float64
+		t           uint8
+		sumB, count int
+	)
+	for v, n := range hist {
+		if count += n; count == 0 {
+			continue
+		}
+		if count == total {
+			break
+		}
+		sumB += v * n
+		mB := float64(sumB) / float64(count)
+		mF := float64(sum-sumB) / float64(total-count)
+		between := float64(count) * float64(total-count) * (mB - mF) * (mB - mF)
+		if between > best {
+			best, t = between, uint8(v+1)
+		}
+	}
+	return t
+}
+
+// Adaptive binarizes the grayscale image against the mean of the
+// window x window neighbourhood of each pixel, minus the offset.
+// It copes with uneven backgrounds better than Otsu.
+func Adaptive(window, offset int) engine.Preprocessor {
+	return filter(func(img image.Image) image.Image {
+		g := toGray(img)
+		w, h := g.Rect.Dx(), g.Rect.Dy()
+		// integral[y][x] is the sum of the pixels above and left of x, y.
+		integral := make([]int, (w+1)*(h+1))
+		for y := 0; y < h; y++ {
+			for x, rowSum := 0, 0; x < w; x++ {
+				rowSum += int(g.Pix[y*g.Stride+x])
+				integral[(y+1)*(w+1)+x+1] = integral[y*(w+1)+x+1] + rowSum
+			}
+		}
+		r := max(window/2, 1)
+		dst := image.NewGray(g.Rect)
+		for y := 0; y < h; y++ {
+			y0, y1 := max(y-r, 0), min(y+r+1, h)
+			for x := 0; x < w; x++ {
+				x0, x1 := max(x-r, 0), min(x+r+1, w)
+				sum := integral[y1*(w+1)+x1] - integral[y0*(w+1)+x1] -
+					integral[y1*(w+1)+x0] + integral[y0*(w+1)+x0]
+				mean := sum / ((x1 - x0) * (y1 - y0))
+				if int(g.Pix[y*g.Stride+x]) >= mean-offset {
+					dst.Pix[y*dst.Stride+x] = 0xff
+				}
+			}
+		}
+		return dst
+	})
+}
+
+// Median replaces each pixel of the grayscale image with the median
+// of its (2*radius+1) squared neighbourhood, removing salt and pepper noise.
+// The image is unchanged if the radius is negative.
+func Median(radius int) engine.Preprocessor {
+	return filter(func(img image.Image) image.Image {
+		if radius < 0 {
+			return img
+		}
+		g := toGray(img)
+		w, h := g.Rect.Dx(), g.Rect.Dy()
+		dst := image.NewGray(g.Rect)
+		window := make([]uint8, 0, (2*radius+1)*(2*radius+1))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				window = window[:0]
+				for wy := max(y-radius, 0); wy < min(y+radius+1, h); wy++ {
+					for wx := max(x-radius, 0); wx < min(x+radius+1, w); wx++ {
+						window = append(window, g.Pix[wy*g.Stride+wx])
+					}
+				}
+				slices.Sort(window)
+				dst.Pix[y*dst.Stride+x] = window[len(window)/2]
+			}
+		}
+		return dst
+	})
+}
+
+// CropToContent crops the image to the bounding box of the pixels darker
+// than its Otsu threshold, plus a margin. The image is unchanged if
+// it has no content.
+func CropToContent(margin int) engine.Preprocessor {
+	return filter(func(img image.Image) image.Image {
+		g := toGray(img)
+		t := OtsuThreshold(g)
+		content := image.Rectangle{}
+		for y := 0; y < g.Rect.Dy(); y++ {
+			for x := 0; x < g.Rect.Dx(); x++ {
+				if g.Pix[y*g.Stride+x] < t {
+					content = content.Union(image.Rect(x, y, x+1, y+1))
+				}
+			}
+		}
+		if content.Empty() {
+			return img
+		}
+		content = content.Inset(-margin).Intersect(g.Rect)
+		b := img.Bounds()
+		dst := image.NewRGBA(image.Rect(0, 0, content.Dx(), content.Dy()))
+		draw.Draw(dst, dst.Bounds(), img, b.Min.Add(content.Min), draw.Src)
+		return dst
+	})
+}
+
+// Resize scales the image to width x height with bilinear interpolation,
+// usually to the input size of the model. The image is unchanged if it
+// or the size is empty.
+func Resize(width, height int) engine.Preprocessor {
+	return filter(func(img image.Image) image.Image {
+		if img.Bounds().Empty() || width < 1 || height < 1 {
+			return img
+		}
+		src := toRGBA(img)
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		sx := float64(src.Rect.Dx()) / float64(width)
+		sy := float64(src.Rect.Dy()) / float64(height)
+		for y := 0; y < height; y++ {
+			fy := (float64(y)+0.5)*sy - 0.5
+			for x := 0; x < width; x++ {
+				fx := (float64(x)+0.5)*sx - 0.5
+				i := dst.PixOffset(x, y)
+				sampling.Sample(dst.Pix[i:i+4], src, fx, fy, sampling.Bilinear)
+			}
+		}
+		return dst
+	})
+}
+
+// threshold returns the binary image of g, pixels below t are black.
+func threshold(g *image.Gray, t uint8) *image.Gray {
+	dst := image.NewGray(g.Rect)
+	for y := 0; y < g.Rect.Dy(); y++ {
+		for x := 0; x < g.Rect.Dx(); x++ {
+			if g.Pix[y*g.Stride+x] >= t {
+				dst.Pix[y*dst.Stride+x] = 0xff
+			}
+		}
+	}
+	return dst
+}
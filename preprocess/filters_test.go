@@ -0,0 +1,234 @@
+package preprocess
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+
+	"giautm.dev/captcha/engine"
+)
+
+// pic returns the grayscale image of the rows, # is black,
+// o is the middle gray and any other byte is white.
+func pic(rows ...string) *image.Gray {
+	g := image.NewGray(image.Rect(0, 0, len(rows[0]), len(rows)))
+	for y, row := range rows {
+		for x := range len(row) {
+			v := uint8(0xff)
+			switch row[x] {
+			case '#':
+				v = 0
+			case 'o':
+				v = 0x80
+			}
+			g.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return g
+}
+
+// rows returns the image as pic rows, the pixels below the
+// middle gray are #, the middle gray o.
+func rows(img image.Image) []string {
+	b := img.Bounds()
+	var result []string
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var sb strings.Builder
+		for x := b.Min.X; x < b.Max.X; x++ {
+			switch v := color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y; {
+			case v < 0x80:
+				sb.WriteByte('#')
+			case v == 0x80:
+				sb.WriteByte('o')
+			default:
+				sb.WriteByte('.')
+			}
+		}
+		result = append(result, sb.String())
+	}
+	return result
+}
+
+func transform(t *testing.T, p engine.Preprocessor, img image.Image) image.Image {
+	t.Helper()
+	got, err := p.Transform(context.Background(), img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestOtsuThreshold(t *testing.T) {
+	tests := []struct {
+		name string
+		img  *image.Gray
+		// ink are the pixels below the threshold.
+		ink []string
+	}{
+		{"two levels", pic("##..", "#..."), []string{"##..", "#..."}},
+		{"three levels", pic("#o..", "#o.."), []string{"##..", "##.."}},
+		{"uniform", pic("oooo"), []string{"...."}},
+		{"white", pic("...."), []string{"...."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := threshold(tt.img, OtsuThreshold(tt.img))
+			if !reflect.DeepEqual(rows(got), tt.ink) {
+				t.Errorf("ink = %q, want %q", rows(got), tt.ink)
+			}
+		})
+	}
+}
+
+func TestAdaptive(t *testing.T) {
+	// A gradient from 100 on the left to 245 on the right, with
+	// a 3x3 dark block 60 below the background at x = 24.
+	g := image.NewGray(image.Rect(0, 0, 30, 10))
+	for y := range 10 {
+		for x := range 30 {
+			v := 100 + 5*x
+			if x >= 24 && x < 27 && y >= 4 && y < 7 {
+				v -= 60
+			}
+			g.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	tests := []struct {
+		name           string
+		window, offset int
+		wantInk        int
+	}{
+		{"block", 15, 20, 9},
+		{"large offset", 15, 80, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transform(t, Adaptive(tt.window, tt.offset), g).(*image.Gray)
+			ink := 0
+			for y := range 10 {
+				for x := range 30 {
+					if got.GrayAt(x, y).Y == 0 {
+						ink++
+						if tt.wantInk > 0 && (x < 24 || x >= 27 || y < 4 || y >= 7) {
+							t.Errorf("ink at %d, %d, want only the block", x, y)
+						}
+					}
+				}
+			}
+			if ink != tt.wantInk {
+				t.Errorf("%d ink pixels, want %d", ink, tt.wantInk)
+			}
+		})
+	}
+	// Otsu marks the dark side of the gradient as ink.
+	if got := transform(t, Otsu(), g).(*image.Gray); got.GrayAt(0, 0).Y != 0 {
+		t.Error("Otsu did not mark the dark background, the test image is too easy")
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		name   string
+		radius int
+		src    []string
+		want   []string
+	}{
+		{"dot", 1, []string{".....", "..#..", "....."}, []string{".....", ".....", "....."}},
+		{"hole", 1, []string{"#####", "##.##", "#####"}, []string{"#####", "#####", "#####"}},
+		{"band", 1, []string{".....", "#####", "#####", "#####", "....."}, []string{".....", "#####", "#####", "#####", "....."}},
+		{"zero radius", 0, []string{"..#", "#.."}, []string{"..#", "#.."}},
+		{"negative radius", -1, []string{"..#", "#.."}, []string{"..#", "#.."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rows(transform(t, Median(tt.radius), pic(tt.src...))); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Median = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCropToContent(t *testing.T) {
+	src := pic(
+		"........",
+		"..##....",
+		"...#....",
+		"........",
+		"........",
+	)
+	tests := []struct {
+		name   string
+		src    *image.Gray
+		margin int
+		want   []string
+	}{
+		{"no margin", src, 0, []string{"##", ".#"}},
+		{"margin", src, 1, []string{"....", ".##.", "..#.", "...."}},
+		{"clamped margin", src, 10, rows(src)},
+		{"blank", pic("....", "...."), 1, []string{"....", "...."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := transform(t, CropToContent(tt.margin), tt.src)
+			if got.Bounds().Min != (image.Point{}) {
+				t.Errorf("origin = %v, want 0, 0", got.Bounds().Min)
+			}
+			if !reflect.DeepEqual(rows(got), tt.want) {
+				t.Errorf("CropToContent = %q, want %q", rows(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name          string
+		src           image.Image
+		width, height int
+		want          []string
+	}{
+		{"down", pic("####", "####", "....", "...."), 2, 2, []string{"##", ".."}},
+		{"up", pic("#."), 4, 1, []string{"##.."}},
+		{"same", pic("#.", ".#"), 2, 2, []string{"#.", ".#"}},
+		{"empty size", pic("#."), 0, 1, []string{"#."}},
+		{"empty image", image.NewGray(image.Rectangle{}), 2, 2, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rows(transform(t, Resize(tt.width, tt.height), tt.src)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Resize = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvert(t *testing.T) {
+	tests := []struct {
+		name string
+		src  image.Image
+		want []uint8
+	}{
+		{"gray", pic("#o."), []uint8{0xff, 0x7f, 0}},
+		{"sub image", pic("#o.").SubImage(image.Rect(1, 0, 3, 1)), []uint8{0x7f, 0}},
+		{"premultiplied", &image.RGBA{Pix: []uint8{0x10, 0x20, 0x30, 0x80}, Stride: 4, Rect: image.Rect(0, 0, 1, 1)},
+			[]uint8{0x70, 0x60, 0x50, 0x80}},
+		{"transparent", image.NewRGBA(image.Rect(0, 0, 1, 1)), []uint8{0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pix []uint8
+			switch got := transform(t, Invert(), tt.src).(type) {
+			case *image.Gray:
+				pix = got.Pix
+			case *image.RGBA:
+				pix = got.Pix
+			}
+			if !reflect.DeepEqual(pix, tt.want) {
+				t.Errorf("Invert = %x, want %x", pix, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package preprocess
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"giautm.dev/captcha/engine"
+	"giautm.dev/captcha/fisheye"
+	"giautm.dev/captcha/sampling"
+)
+
+// Factory creates a Preprocessor from the argument of its name
+// in a pipeline spec, the argument is empty if none is given.
+type Factory func(arg string) (engine.Preprocessor, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = map[string]Factory{
+		"fisheye":   fisheyeArg,
+		"grayscale": noArg(Grayscale),
+		"invert":    noArg(Invert),
+		"otsu":      noArg(Otsu),
+		"adaptive": func(arg string) (engine.Preprocessor, error) {
+			window, offset, err := intPair(arg, ":", 15, 10)
+			return Adaptive(window, offset), err
+		},
+		"median": func(arg string) (engine.Preprocessor, error) {
+			radius, err := intArg(arg, 1)
+			if err == nil && radius < 0 {
+				err = errors.New("radius must not be negative")
+			}
+			return Median(radius), err
+		},
+		"crop": func(arg string) (engine.Preprocessor, error) {
+			margin, err := intArg(arg, 0)
+			return CropToContent(margin), err
+		},
+		"denoise": func(arg string) (engine.Preprocessor, error) {
+			r := NewLineRemover()
+			if arg == "" {
+				return r, nil
+			}
+			parts := strings.Split(arg, ":")
+			if len(parts) != 4 {
+				return nil, errors.New("needs width:length:crossing:area, like denoise=2:20:8:4")
+			}
+			for i, v := range []*int{&r.MaxLineWidth, &r.MinLineLength, &r.MaxCrossing, &r.MaxDotArea} {
+				n, err := strconv.Atoi(parts[i])
+				if err != nil {
+					return nil, err
+				}
+				*v = n
+			}
+			return r, nil
+		},
+		"deskew": func(arg string) (engine.Preprocessor, error) {
+			d := NewDeskew()
+			if arg == "" {
+				return d, nil
+			}
+			angle, err := strconv.ParseFloat(arg, 64)
+			d.MaxAngle = angle
+			return d, err
+		},
+		"resize": func(arg string) (engine.Preprocessor, error) {
+			if arg == "" {
+				return nil, errors.New("needs a size, like resize=180x50")
+			}
+			w, h, err := intPair(arg, "x", 0, 0)
+			if err == nil && (w < 1 || h < 1) {
+				err = errors.New("size must be positive")
+			}
+			return Resize(w, h), err
+		},
+	}
+)
+
+// Register makes a Preprocessor available by name to Parse.
+func Register(name string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = f
+}
+
+// Parse returns the Chain of the comma separated pipeline spec,
+// where each filter is a name with an optional =argument, like
+// "fisheye=auto:inverse,grayscale,median=1,otsu,crop=2,resize=180x50".
+func Parse(spec string) (engine.Preprocessor, error) {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	var ps []engine.Preprocessor
+	for _, f := range strings.Split(spec, ",") {
+		if f = strings.TrimSpace(f); f == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(f, "=")
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("preprocess: unknown filter %q", name)
+		}
+		p, err := factory(arg)
+		if err != nil {
+			return nil, fmt.Errorf("preprocess: %s: %w", name, err)
+		}
+		ps = append(ps, p)
+	}
+	return Chain(ps...), nil
+}
+
+// fisheyeArg parses the row:mapping argument of the fisheye filter,
+// the row is a number or auto, the mapping forward or inverse, like
+// fisheye=auto:inverse. The inverse mapping samples bilinearly.
+func fisheyeArg(arg string) (engine.Preprocessor, error) {
+	p := fisheye.NewPreprocessor()
+	if arg == "" {
+		return p, nil
+	}
+	row, mapping, _ := strings.Cut(arg, ":")
+	if row != "auto" {
+		n, err := strconv.Atoi(row)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid row %q, needs a number or auto", row)
+		}
+		p.TestRowIndex = n
+	}
+	switch mapping {
+	case "", "forward":
+	case "inverse":
+		p.Mapping, p.Interpolation = fisheye.InverseMapping, sampling.Bilinear
+	default:
+		return nil, fmt.Errorf("invalid mapping %q, needs forward or inverse", mapping)
+	}
+	return p, nil
+}
+
+func noArg(fn func() engine.Preprocessor) Factory {
+	return func(string) (engine.Preprocessor, error) {
+		return fn(), nil
+	}
+}
+
+func intArg(arg string, fallback int) (int, error) {
+	if arg == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(arg)
+}
+
+func intPair(arg, sep string, fa, fb int) (int, int, error) {
+	if arg == "" {
+		return fa, fb, nil
+	}
+	sa, sb, ok := strings.Cut(arg, sep)
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid argument %q", arg)
+	}
+	a, err := strconv.Atoi(sa)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := strconv.Atoi(sb)
+	return a, b, err
+}
@@ -0,0 +1,85 @@
+package preprocess
+
+import (
+	"testing"
+
+	"giautm.dev/captcha/fisheye"
+	"giautm.dev/captcha/sampling"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec   string
+		stages int
+		ok     bool
+	}{
+		{"", 0, true},
+		{" , ", 0, true},
+		{"grayscale", 1, true},
+		{"fisheye,grayscale,median=1,otsu,crop=2,resize=180x50", 6, true},
+		{"adaptive,adaptive=21:5,invert", 3, true},
+		{"denoise,denoise=2:20:8:4,deskew,deskew=10", 4, true},
+		{"fisheye=auto,fisheye=25:forward,fisheye=auto:inverse", 3, true},
+		{"unknown", 0, false},
+		{"median=-1", 0, false},
+		{"median=x", 0, false},
+		{"crop=x", 0, false},
+		{"adaptive=21", 0, false},
+		{"adaptive=21:x", 0, false},
+		{"resize", 0, false},
+		{"resize=0x50", 0, false},
+		{"resize=180", 0, false},
+		{"denoise=2:20", 0, false},
+		{"denoise=2:20:8:x", 0, false},
+		{"deskew=x", 0, false},
+		{"fisheye=x", 0, false},
+		{"fisheye=-2", 0, false},
+		{"fisheye=auto:sideways", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			p, err := Parse(tt.spec)
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("Parse succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(p.(chain)); n != tt.stages {
+				t.Errorf("%d stages, want %d", n, tt.stages)
+			}
+		})
+	}
+}
+
+func TestParseFisheye(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want fisheye.FisheyePreprocessor
+	}{
+		{"", *fisheye.NewPreprocessor()},
+		{"auto", *fisheye.NewPreprocessor()},
+		{"25", fisheye.FisheyePreprocessor{TestRowIndex: 25, TestRows: 3}},
+		{"0:forward", fisheye.FisheyePreprocessor{TestRowIndex: 0, TestRows: 3}},
+		{"auto:inverse", fisheye.FisheyePreprocessor{
+			TestRowIndex:  fisheye.AutoTestRow,
+			TestRows:      3,
+			Mapping:       fisheye.InverseMapping,
+			Interpolation: sampling.Bilinear,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			p, err := fisheyeArg(tt.arg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := *p.(*fisheye.FisheyePreprocessor); got != tt.want {
+				t.Errorf("fisheye = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
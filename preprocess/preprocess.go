@@ -0,0 +1,69 @@
+package preprocess
+
+import (
+	"context"
+	"image"
+	"image/draw"
+
+	"giautm.dev/captcha/engine"
+)
+
+type (
+	// Func is an adapter to use ordinary functions as engine.Preprocessor.
+	Func  func(ctx context.Context, img image.Image) (image.Image, error)
+	chain []engine.Preprocessor
+)
+
+// Transform implements the Preprocessor interface.
+func (fn Func) Transform(ctx context.Context, img image.Image) (image.Image, error) {
+	return fn(ctx, img)
+}
+
+// Chain returns a Preprocessor applying the preprocessors in order.
+// An empty chain returns the image unchanged.
+func Chain(ps ...engine.Preprocessor) engine.Preprocessor {
+	return chain(ps)
+}
+
+// Transform implements the Preprocessor interface.
+func (c chain) Transform(ctx context.Context, img image.Image) (image.Image, error) {
+	var err error
+	for _, p := range c {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if img, err = p.Transform(ctx, img); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// filter returns a Preprocessor from a function that can not fail.
+func filter(fn func(image.Image) image.Image) engine.Preprocessor {
+	return Func(func(_ context.Context, img image.Image) (image.Image, error) {
+		return fn(img), nil
+	})
+}
+
+// toGray returns the image as *image.Gray with its origin at 0, 0.
+func toGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	if g, ok := img.(*image.Gray); ok && b.Min == (image.Point{}) {
+		return g
+	}
+	g := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(g, g.Bounds(), img, b.Min, draw.Src)
+	return g
+}
+
+// toRGBA returns the image as *image.RGBA with its origin at 0, 0.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	if r, ok := img.(*image.RGBA); ok && b.Min == (image.Point{}) {
+		return r
+	}
+	r := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(r, r.Bounds(), img, b.Min, draw.Src)
+	return r
+}
@@ -0,0 +1,54 @@
+// Package sampling samples images at fractional coordinates,
+// for the filters that map the pixels of an image.
+package sampling
+
+import (
+	"image"
+	"math"
+)
+
+// Interpolation is the way Sample blends the source pixels.
+type Interpolation int
+
+const (
+	// Nearest samples the nearest source pixel.
+	Nearest Interpolation = iota
+	// Bilinear blends the four nearest source pixels.
+	Bilinear
+)
+
+// Sample writes the pixel of src at x, y into the 4 bytes of dst,
+// with the interpolation. The points outside src take the nearest
+// pixel of its edge.
+func Sample(dst []uint8, src *image.RGBA, x, y float64, interp Interpolation) {
+	if interp == Bilinear {
+		sampleBilinear(dst, src, x, y)
+	} else {
+		sampleNearest(dst, src, x, y)
+	}
+}
+
+func sampleNearest(dst []uint8, src *image.RGBA, x, y float64) {
+	p := clampPoint(src.Bounds(), int(math.Round(x)), int(math.Round(y)))
+	i := src.PixOffset(p.X, p.Y)
+	copy(dst, src.Pix[i:i+4])
+}
+
+func sampleBilinear(dst []uint8, src *image.RGBA, x, y float64) {
+	b := src.Bounds()
+	fx, fy := math.Floor(x), math.Floor(y)
+	tx, ty := x-fx, y-fy
+	p00 := clampPoint(b, int(fx), int(fy))
+	p11 := clampPoint(b, int(fx)+1, int(fy)+1)
+	i00, i10 := src.PixOffset(p00.X, p00.Y), src.PixOffset(p11.X, p00.Y)
+	i01, i11 := src.PixOffset(p00.X, p11.Y), src.PixOffset(p11.X, p11.Y)
+	for c := range 4 {
+		top := float64(src.Pix[i00+c])*(1-tx) + float64(src.Pix[i10+c])*tx
+		bottom := float64(src.Pix[i01+c])*(1-tx) + float64(src.Pix[i11+c])*tx
+		dst[c] = uint8(top*(1-ty) + bottom*ty + 0.5)
+	}
+}
+
+func clampPoint(b image.Rectangle, x, y int) image.Point {
+	return image.Pt(min(max(x, b.Min.X), b.Max.X-1), min(max(y, b.Min.Y), b.Max.Y-1))
+}
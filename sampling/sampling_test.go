@@ -0,0 +1,38 @@
+package sampling
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	// A 2x2 image with the gray levels 0, 100, 200 and 255.
+	src := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	for i, v := range []uint8{0, 100, 200, 255} {
+		copy(src.Pix[i*4:], []uint8{v, v, v, 0xff})
+	}
+	tests := []struct {
+		name   string
+		x, y   float64
+		interp Interpolation
+		want   uint8
+	}{
+		{"nearest", 0.4, 0.6, Nearest, 200},
+		{"nearest rounded", 0.5, 0, Nearest, 100},
+		{"nearest outside", -3, 5, Nearest, 200},
+		{"bilinear pixel", 1, 1, Bilinear, 255},
+		{"bilinear between columns", 0.5, 0, Bilinear, 50},
+		{"bilinear center", 0.5, 0.5, Bilinear, 139},
+		{"bilinear outside", 4, -1, Bilinear, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := make([]uint8, 4)
+			Sample(dst, src, tt.x, tt.y, tt.interp)
+			if want := []uint8{tt.want, tt.want, tt.want, 0xff}; !reflect.DeepEqual(dst, want) {
+				t.Errorf("Sample = %v, want %v", dst, want)
+			}
+		})
+	}
+}
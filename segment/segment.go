@@ -0,0 +1,195 @@
+package segment
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/draw"
+
+	"giautm.dev/captcha/preprocess"
+)
+
+// Splitter is an engine.Splitter that segments the characters by the
+// vertical projection of the ink, and crops each one for the SymbolResolver.
+// Touching characters are split at the column with the least ink.
+type Splitter struct {
+	// Count is the expected number of characters, segments are split or
+	// merged to match it, Split fails with ErrNoGlyphs if they can not be.
+	// 0 accepts any number of segments.
+	Count int
+	// MinWidth is the narrowest segment kept, narrower ones are noise.
+	// It is at least 1.
+	MinWidth int
+	// MaxWidth is the widest single character, wider segments are split.
+	// 0 means no limit.
+	MaxWidth int
+	// Padding is the white margin around each cropped character.
+	Padding int
+	// GlyphSize is the size each character is resized to, usually the
+	// input size of the model. The zero value keeps the cropped size.
+	GlyphSize image.Point
+}
+
+var (
+	ErrNoGlyphs = errors.New("segment: no characters found")
+)
+
+// NewSplitter creates a Splitter expecting count characters.
+func NewSplitter(count int) *Splitter {
+	return &Splitter{
+		Count:    count,
+		MinWidth: 2,
+		Padding:  2,
+	}
+}
+
+// Split implements the engine.Splitter interface.
+func (s *Splitter) Split(ctx context.Context, img image.Image) ([]image.Image, error) {
+	gray, err := preprocess.Grayscale().Transform(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	g := gray.(*image.Gray)
+	ink := inkColumns(g, preprocess.OtsuThreshold(g))
+	segments := s.segments(ink)
+	if len(segments) == 0 {
+		return nil, ErrNoGlyphs
+	}
+	glyphs := make([]image.Image, len(segments))
+	for i, seg := range segments {
+		if glyphs[i], err = s.crop(ctx, img, g, seg); err != nil {
+			return nil, err
+		}
+	}
+	return glyphs, nil
+}
+
+// span is a range of columns [from, to).
+type span struct{ from, to int }
+
+func (sp span) width() int { return sp.to - sp.from }
+
+// segments returns the runs of ink columns, split or merged
+// to match the limits of the Splitter, or nil if they can not
+// be split up to the Count.
+func (s *Splitter) segments(ink []int) []span {
+	minWidth := max(s.MinWidth, 1)
+	var segments []span
+	for x := 0; x < len(ink); {
+		if ink[x] == 0 {
+			x++
+			continue
+		}
+		from := x
+		for x < len(ink) && ink[x] > 0 {
+			x++
+		}
+		if x-from >= minWidth {
+			segments = append(segments, span{from, x})
+		}
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+	for s.MaxWidth > 0 {
+		i := widest(segments)
+		if segments[i].width() <= s.MaxWidth {
+			break
+		}
+		segments = splitAt(segments, i, ink)
+	}
+	for s.Count > 0 && len(segments) < s.Count {
+		i := widest(segments)
+		if segments[i].width() < 2*minWidth {
+			return nil
+		}
+		segments = splitAt(segments, i, ink)
+	}
+	for s.Count > 0 && len(segments) > s.Count {
+		segments = mergeClosest(segments)
+	}
+	return segments
+}
+
+// splitAt splits the i-th segment at its column with the least ink,
+// searched in its middle half so both parts keep a character's width.
+// The segment is at least 2 columns wide, so neither part is empty.
+func splitAt(segments []span, i int, ink []int) []span {
+	seg := segments[i]
+	from, to := seg.from+seg.width()/4, seg.to-seg.width()/4
+	cut := (seg.from + seg.to) / 2
+	for x := from; x < to; x++ {
+		if ink[x] < ink[cut] {
+			cut = x
+		}
+	}
+	if cut <= seg.from {
+		cut = seg.from + 1
+	}
+	return append(segments[:i], append([]span{{seg.from, cut}, {cut, seg.to}}, segments[i+1:]...)...)
+}
+
+// mergeClosest merges the two neighbour segments with the smallest gap,
+// that is the narrowest merged width on ties.
+func mergeClosest(segments []span) []span {
+	best := 0
+	for i := 1; i < len(segments)-1; i++ {
+		gap, bestGap := segments[i+1].from-segments[i].to, segments[best+1].from-segments[best].to
+		if gap < bestGap || (gap == bestGap &&
+			segments[i+1].to-segments[i].from < segments[best+1].to-segments[best].from) {
+			best = i
+		}
+	}
+	segments[best].to = segments[best+1].to
+	return append(segments[:best+1], segments[best+2:]...)
+}
+
+func widest(segments []span) int {
+	w := 0
+	for i, seg := range segments {
+		if seg.width() > segments[w].width() {
+			w = i
+		}
+	}
+	return w
+}
+
+// inkColumns returns the number of ink pixels of each column.
+func inkColumns(g *image.Gray, t uint8) []int {
+	ink := make([]int, g.Rect.Dx())
+	for y := 0; y < g.Rect.Dy(); y++ {
+		for x := range ink {
+			if g.Pix[y*g.Stride+x] < t {
+				ink[x]++
+			}
+		}
+	}
+	return ink
+}
+
+// crop returns the columns of the segment, cropped to the rows
+// with ink, with the padding, resized to the GlyphSize.
+func (s *Splitter) crop(ctx context.Context, img image.Image, g *image.Gray, seg span) (image.Image, error) {
+	t := preprocess.OtsuThreshold(g)
+	top, bottom := g.Rect.Dy(), 0
+	for y := 0; y < g.Rect.Dy(); y++ {
+		for x := seg.from; x < seg.to; x++ {
+			if g.Pix[y*g.Stride+x] < t {
+				top, bottom = min(top, y), max(bottom, y+1)
+				break
+			}
+		}
+	}
+	if top >= bottom {
+		top, bottom = 0, g.Rect.Dy()
+	}
+	b := img.Bounds()
+	r := image.Rect(seg.from, top, seg.to, bottom)
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx()+2*s.Padding, r.Dy()+2*s.Padding))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, r.Sub(r.Min).Add(image.Pt(s.Padding, s.Padding)), img, b.Min.Add(r.Min), draw.Over)
+	if s.GlyphSize == (image.Point{}) {
+		return dst, nil
+	}
+	return preprocess.Resize(s.GlyphSize.X, s.GlyphSize.Y).Transform(ctx, dst)
+}
@@ -0,0 +1,131 @@
+package segment
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+// columns returns an image of len(ink) columns and the height, the
+// column x has ink[x] black pixels from the top.
+func columns(height int, ink ...int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, len(ink), height))
+	for y := range height {
+		for x, n := range ink {
+			img.SetGray(x, y, color.Gray{Y: 0xff})
+			if y < n {
+				img.SetGray(x, y, color.Gray{})
+			}
+		}
+	}
+	return img
+}
+
+func TestSplitterSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		splitter Splitter
+		img      image.Image
+		// widths of the glyphs, or nil for ErrNoGlyphs.
+		widths []int
+	}{
+		{
+			name:     "separated",
+			splitter: Splitter{Count: 3, MinWidth: 1},
+			img:      columns(4, 4, 4, 0, 4, 4, 4, 0, 0, 4),
+			widths:   []int{2, 3, 1},
+		},
+		{
+			name:     "any count",
+			splitter: Splitter{MinWidth: 1},
+			img:      columns(4, 4, 0, 4, 4, 0, 4),
+			widths:   []int{1, 2, 1},
+		},
+		{
+			name:     "noise",
+			splitter: Splitter{MinWidth: 2},
+			img:      columns(4, 4, 0, 4, 4, 0, 4),
+			widths:   []int{2},
+		},
+		{
+			name:     "touching",
+			splitter: Splitter{Count: 2, MinWidth: 1},
+			img:      columns(4, 4, 4, 4, 4, 1, 4, 4, 4),
+			widths:   []int{4, 4},
+		},
+		{
+			name:     "too wide",
+			splitter: Splitter{MinWidth: 1, MaxWidth: 4},
+			img:      columns(4, 4, 4, 4, 4, 1, 4, 4, 4),
+			widths:   []int{4, 4},
+		},
+		{
+			name:     "merged",
+			splitter: Splitter{Count: 2, MinWidth: 1},
+			img:      columns(4, 4, 4, 0, 0, 0, 4, 0, 4),
+			widths:   []int{2, 3},
+		},
+		{
+			name:     "too narrow to split",
+			splitter: Splitter{Count: 3, MinWidth: 2},
+			img:      columns(4, 4, 4, 4, 0, 4, 4),
+			widths:   nil,
+		},
+		{
+			name:     "single pixel",
+			splitter: Splitter{Count: 5},
+			img:      columns(3, 0, 1, 0),
+			widths:   nil,
+		},
+		{
+			name:     "blank",
+			splitter: Splitter{Count: 1},
+			img:      columns(3, 0, 0, 0),
+			widths:   nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			glyphs, err := tt.splitter.Split(context.Background(), tt.img)
+			if tt.widths == nil {
+				if !errors.Is(err, ErrNoGlyphs) {
+					t.Fatalf("Split = %d glyphs, %v, want %v", len(glyphs), err, ErrNoGlyphs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			widths := make([]int, len(glyphs))
+			for i, g := range glyphs {
+				widths[i] = g.Bounds().Dx()
+			}
+			if !reflect.DeepEqual(widths, tt.widths) {
+				t.Errorf("widths = %v, want %v", widths, tt.widths)
+			}
+		})
+	}
+}
+
+func TestSplitterCrop(t *testing.T) {
+	s := &Splitter{Count: 1, MinWidth: 1, Padding: 2, GlyphSize: image.Pt(8, 8)}
+	glyphs, err := s.Split(context.Background(), columns(6, 0, 3, 3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(glyphs) != 1 || glyphs[0].Bounds() != image.Rect(0, 0, 8, 8) {
+		t.Fatalf("glyphs = %v, want one resized to 8x8", glyphs)
+	}
+	s.GlyphSize = image.Point{}
+	glyphs, err = s.Split(context.Background(), columns(6, 0, 3, 3, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The glyph is cropped to the rows with ink, then padded.
+	if want := image.Rect(0, 0, 2+4, 3+4); glyphs[0].Bounds() != want {
+		t.Errorf("bounds = %v, want %v", glyphs[0].Bounds(), want)
+	}
+}
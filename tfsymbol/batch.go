@@ -0,0 +1,93 @@
+package tfsymbol
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type (
+	// microBatcher merges the instances of concurrent predictions
+	// into a single request.
+	microBatcher struct {
+		window  time.Duration
+		maxSize int
+		// timeout bounds the request of a batch.
+		timeout time.Duration
+		send    func(context.Context, [][][][]float32) ([][]float32, error)
+
+		mu      sync.Mutex
+		pending *batch
+	}
+	batch struct {
+		once        sync.Once
+		timer       *time.Timer
+		instances   [][][][]float32
+		predictions [][]float32
+		err         error
+		done        chan struct{}
+	}
+)
+
+// predict adds the instances to the pending batch and waits for their predictions.
+// The pending batch is flushed first if the instances would overflow it, and
+// the instances filling a batch on their own are sent alone.
+func (b *microBatcher) predict(ctx context.Context, instances [][][][]float32) ([][]float32, error) {
+	b.mu.Lock()
+	if bt := b.pending; bt != nil && len(bt.instances)+len(instances) > b.maxSize {
+		b.pending = nil
+		bt.timer.Stop()
+		go b.flush(bt)
+	}
+	if len(instances) >= b.maxSize {
+		b.mu.Unlock()
+		bt := &batch{instances: instances, done: make(chan struct{})}
+		go b.flush(bt)
+		return bt.wait(ctx, 0, len(instances))
+	}
+	bt := b.pending
+	if bt == nil {
+		bt = &batch{done: make(chan struct{})}
+		bt.timer = time.AfterFunc(b.window, func() { b.flush(bt) })
+		b.pending = bt
+	}
+	offset := len(bt.instances)
+	bt.instances = append(bt.instances, instances...)
+	if len(bt.instances) >= b.maxSize {
+		b.pending = nil
+		bt.timer.Stop()
+		go b.flush(bt)
+	}
+	b.mu.Unlock()
+	return bt.wait(ctx, offset, len(instances))
+}
+
+// wait waits for the n predictions of the batch from offset.
+func (bt *batch) wait(ctx context.Context, offset, n int) ([][]float32, error) {
+	select {
+	case <-bt.done:
+		if bt.err != nil {
+			return nil, bt.err
+		}
+		return bt.predictions[offset : offset+n], nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// flush sends the batch. The request is not bound to any caller's context,
+// since the callers of a batch can be canceled independently, but to the
+// timeout of the batcher.
+func (b *microBatcher) flush(bt *batch) {
+	b.mu.Lock()
+	if b.pending == bt {
+		b.pending = nil
+	}
+	b.mu.Unlock()
+	bt.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+		defer cancel()
+		bt.predictions, bt.err = b.send(ctx, bt.instances)
+		close(bt.done)
+	})
+}
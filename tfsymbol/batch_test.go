@@ -0,0 +1,110 @@
+package tfsymbol
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// instances returns n instances whose only value is their id.
+func instances(ids ...float32) [][][][]float32 {
+	out := make([][][][]float32, len(ids))
+	for i, id := range ids {
+		out[i] = [][][]float32{{{id}}}
+	}
+	return out
+}
+
+// echo is a send function returning the id of each instance as its
+// prediction, and recording the size of each request.
+type echo struct {
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (e *echo) send(_ context.Context, in [][][][]float32) ([][]float32, error) {
+	e.mu.Lock()
+	e.sizes = append(e.sizes, len(in))
+	e.mu.Unlock()
+	out := make([][]float32, len(in))
+	for i, inst := range in {
+		out[i] = []float32{inst[0][0][0]}
+	}
+	return out, nil
+}
+
+func TestMicroBatcherMaxSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int
+		calls   [][]float32
+	}{
+		{"merged", 8, [][]float32{{1, 2, 3}, {4, 5, 6}}},
+		{"overflow", 4, [][]float32{{1, 2, 3}, {4, 5, 6}}},
+		{"oversized", 2, [][]float32{{1, 2, 3}, {4}}},
+		{"exact", 3, [][]float32{{1, 2, 3}, {4, 5, 6}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &echo{}
+			b := &microBatcher{window: 20 * time.Millisecond, maxSize: tt.maxSize, timeout: time.Second, send: e.send}
+			var wg sync.WaitGroup
+			for _, ids := range tt.calls {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got, err := b.predict(context.Background(), instances(ids...))
+					if err != nil {
+						t.Errorf("predict: %v", err)
+						return
+					}
+					for i, p := range got {
+						if p[0] != ids[i] {
+							t.Errorf("prediction %d = %v, want %v", i, p[0], ids[i])
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			total := 0
+			for _, n := range e.sizes {
+				total += n
+				if n > tt.maxSize && !oversized(tt.calls, n, tt.maxSize) {
+					t.Errorf("request sizes %v, want at most %d", e.sizes, tt.maxSize)
+				}
+			}
+			if want := len(tt.calls[0]) + len(tt.calls[1]); total != want {
+				t.Errorf("sent %d instances, want %d", total, want)
+			}
+		})
+	}
+}
+
+// oversized reports whether a call has n instances, more than maxSize,
+// which are sent on their own.
+func oversized(calls [][]float32, n, maxSize int) bool {
+	for _, c := range calls {
+		if len(c) == n && n > maxSize {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMicroBatcherTimeout(t *testing.T) {
+	b := &microBatcher{
+		window:  time.Millisecond,
+		maxSize: 4,
+		timeout: 10 * time.Millisecond,
+		send: func(ctx context.Context, _ [][][][]float32) ([][]float32, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	_, err := b.predict(context.Background(), instances(1))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("predict error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
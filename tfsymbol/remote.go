@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"net/http"
 	"net/url"
+	"time"
+
+	"giautm.dev/captcha/engine"
 )
 
 type (
@@ -15,8 +19,11 @@ type (
 	// of the image using the TensorFlow Serving server.
 	RemoteResolver struct {
 		client     HTTPDoer
+		modelURL   string
 		predictURL string
 		labels     LabelLookup
+		topK       int
+		batcher    *microBatcher
 	}
 	// LabelLookup is an interface that provides a way to
 	// look up the label of the symbol.
@@ -24,6 +31,20 @@ type (
 		// BestMatch returns the label with the highest probability.
 		BestMatch(probabilities []float32) (string, error)
 	}
+	// RankedLookup is a LabelLookup that can also rank the labels
+	// by their probabilities.
+	RankedLookup interface {
+		LabelLookup
+		// TopK returns the k labels with the highest probabilities.
+		TopK(probabilities []float32, k int) ([]engine.SymbolCandidate, error)
+	}
+	// FilteredLookup is a RankedLookup that can also restrict the labels
+	// to the allowed ones, it fails with ErrNoAllowedLabel if none is.
+	FilteredLookup interface {
+		RankedLookup
+		BestAllowedMatch(probabilities []float32, allowed engine.SymbolFilter) (string, error)
+		TopKAllowed(probabilities []float32, k int, allowed engine.SymbolFilter) ([]engine.SymbolCandidate, error)
+	}
 	// Option is a function that sets an option on the RemoteResolver.
 	Option func(*options) error
 	// HTTPDoer is an interface that provides a way to make HTTP requests.
@@ -60,6 +81,49 @@ func WithModelName(name string) Option {
 	}
 }
 
+// WithTopK sets the number of candidates, including the best match,
+// reported by SymbolResolveScored.
+//
+// The default is 3.
+func WithTopK(k int) Option {
+	return func(o *options) error {
+		if k < 1 {
+			return fmt.Errorf("tfsymbol: top-k must be positive")
+		}
+		o.topK = k
+		return nil
+	}
+}
+
+// WithMicroBatch merges the predictions of concurrent calls, arriving within
+// the window, into a single request of at most maxSize instances. A call of
+// more instances is sent on its own.
+//
+// It is disabled by default.
+func WithMicroBatch(window time.Duration, maxSize int) Option {
+	return func(o *options) error {
+		if window <= 0 || maxSize < 1 {
+			return fmt.Errorf("tfsymbol: invalid micro-batch window or size")
+		}
+		o.batchWindow, o.batchSize = window, maxSize
+		return nil
+	}
+}
+
+// WithMicroBatchTimeout sets the timeout of the merged requests, which
+// are not bound to the context of any call.
+//
+// The default is 30 seconds.
+func WithMicroBatchTimeout(d time.Duration) Option {
+	return func(o *options) error {
+		if d <= 0 {
+			return fmt.Errorf("tfsymbol: micro-batch timeout must be positive")
+		}
+		o.batchTimeout = d
+		return nil
+	}
+}
+
 // NewRemoteResolver creates a new RemoteResolver that uses the TensorFlow Serving server to resolve symbols.
 func NewRemoteResolver(labels LabelLookup, opt ...Option) (*RemoteResolver, error) {
 	if labels == nil {
@@ -70,45 +134,205 @@ func NewRemoteResolver(labels LabelLookup, opt ...Option) (*RemoteResolver, erro
 		baseURL: b,
 		client:  http.DefaultClient,
 		model:   "resnet",
+		topK:    3,
+
+		batchTimeout: 30 * time.Second,
 	}
 	for _, o := range opt {
 		if err := o(opts); err != nil {
 			return nil, err
 		}
 	}
-	predictURL, err := opts.baseURL.Parse(
-		fmt.Sprintf("/v1/models/%s:predict", opts.model))
+	modelURL, err := opts.baseURL.Parse(
+		fmt.Sprintf("/v1/models/%s", opts.model))
 	if err != nil {
 		return nil, err
 	}
-	return &RemoteResolver{
-		predictURL: predictURL.String(),
-		client:     http.DefaultClient,
+	r := &RemoteResolver{
+		modelURL:   modelURL.String(),
+		predictURL: modelURL.String() + ":predict",
+		client:     opts.client,
 		labels:     labels,
-	}, nil
+		topK:       opts.topK,
+	}
+	if opts.batchWindow > 0 {
+		r.batcher = &microBatcher{
+			window:  opts.batchWindow,
+			maxSize: opts.batchSize,
+			timeout: opts.batchTimeout,
+			send:    r.send,
+		}
+	}
+	return r, nil
+}
+
+// Ready checks that the model has at least one available version
+// on the TensorFlow Serving server.
+func (s *RemoteResolver) Ready(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.modelURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("tfsymbol: model status: %s", res.Status)
+	}
+	var data modelStatusResponse
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return err
+	}
+	for _, v := range data.ModelVersionStatus {
+		if v.State == "AVAILABLE" {
+			return nil
+		}
+	}
+	return fmt.Errorf("tfsymbol: model is not available")
 }
 
 // SymbolResolve resolves the symbol of the image using the TensorFlow Serving server.
+//
+// If the labels implement FilteredLookup, the symbol is the best one allowed
+// by engine.SymbolFilterFromContext.
 func (s *RemoteResolver) SymbolResolve(ctx context.Context, img image.Image) (string, error) {
-	input := predictRequest{Instances: ImageToTensorValue(img)}
+	predictions, err := s.predict(ctx, img)
+	if err != nil {
+		return "", err
+	}
+	return s.bestMatch(predictions[0], engine.SymbolFilterFromContext(ctx))
+}
+
+// SymbolResolveScored resolves the symbol of the image and reports its probability
+// with the top-k alternatives. Alternatives are only reported if the labels
+// implement RankedLookup.
+func (s *RemoteResolver) SymbolResolveScored(ctx context.Context, img image.Image) (*engine.SymbolResult, error) {
+	predictions, err := s.predict(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	return s.scoreSymbol(predictions[0], engine.SymbolFilterFromContext(ctx))
+}
+
+// bestMatch returns the best allowed label, or the best label
+// if none is allowed.
+func (s *RemoteResolver) bestMatch(probabilities []float32, allowed engine.SymbolFilter) (string, error) {
+	if fl, ok := s.labels.(FilteredLookup); ok && allowed != nil {
+		symbol, err := fl.BestAllowedMatch(probabilities, allowed)
+		if !errors.Is(err, ErrNoAllowedLabel) {
+			return symbol, err
+		}
+	}
+	return s.labels.BestMatch(probabilities)
+}
+
+func (s *RemoteResolver) scoreSymbol(probabilities []float32, allowed engine.SymbolFilter) (*engine.SymbolResult, error) {
+	if rl, ok := s.labels.(RankedLookup); ok {
+		candidates, err := rl.TopK(probabilities, s.topK)
+		if fl, ok := rl.(FilteredLookup); ok && allowed != nil {
+			if c, ferr := fl.TopKAllowed(probabilities, s.topK, allowed); !errors.Is(ferr, ErrNoAllowedLabel) {
+				candidates, err = c, ferr
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &engine.SymbolResult{
+			Symbol:       candidates[0].Symbol,
+			Probability:  candidates[0].Probability,
+			Alternatives: candidates[1:],
+		}, nil
+	}
+	symbol, err := s.bestMatch(probabilities, allowed)
+	if err != nil {
+		return nil, err
+	}
+	result := &engine.SymbolResult{Symbol: symbol}
+	for _, p := range probabilities {
+		result.Probability = max(result.Probability, p)
+	}
+	return result, nil
+}
+
+// SymbolResolveBatch resolves the symbols of the images in one request.
+func (s *RemoteResolver) SymbolResolveBatch(ctx context.Context, imgs []image.Image) ([]string, error) {
+	predictions, err := s.predict(ctx, imgs...)
+	if err != nil {
+		return nil, err
+	}
+	filters := engine.BatchSymbolFiltersFromContext(ctx)
+	symbols := make([]string, len(predictions))
+	for i, p := range predictions {
+		if symbols[i], err = s.bestMatch(p, filterAt(filters, i)); err != nil {
+			return nil, err
+		}
+	}
+	return symbols, nil
+}
+
+// SymbolResolveScoredBatch is the batch variant of SymbolResolveScored.
+func (s *RemoteResolver) SymbolResolveScoredBatch(ctx context.Context, imgs []image.Image) ([]engine.SymbolResult, error) {
+	predictions, err := s.predict(ctx, imgs...)
+	if err != nil {
+		return nil, err
+	}
+	filters := engine.BatchSymbolFiltersFromContext(ctx)
+	results := make([]engine.SymbolResult, len(predictions))
+	for i, p := range predictions {
+		r, err := s.scoreSymbol(p, filterAt(filters, i))
+		if err != nil {
+			return nil, err
+		}
+		results[i] = *r
+	}
+	return results, nil
+}
+
+func filterAt(filters []engine.SymbolFilter, i int) engine.SymbolFilter {
+	if i < len(filters) {
+		return filters[i]
+	}
+	return nil
+}
+
+// predict returns the probabilities of each image, in the same order.
+func (s *RemoteResolver) predict(ctx context.Context, imgs ...image.Image) ([][]float32, error) {
+	instances := make([][][][]float32, len(imgs))
+	for i, img := range imgs {
+		instances[i] = ImageToTensorValue(img)[0]
+	}
+	if s.batcher != nil {
+		return s.batcher.predict(ctx, instances)
+	}
+	return s.send(ctx, instances)
+}
+
+func (s *RemoteResolver) send(ctx context.Context, instances [][][][]float32) ([][]float32, error) {
+	input := predictRequest{Instances: instances}
 	buf := bytes.NewBuffer(nil)
 	if err := json.NewEncoder(buf).Encode(input); err != nil {
-		return "", err
+		return nil, err
 	}
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.predictURL, buf)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	res, err := s.client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer res.Body.Close()
 	var data predictResponse
 	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
-		return "", err
+		return nil, err
 	}
-	return s.labels.BestMatch(data.Predictions[0])
+	if len(data.Predictions) != len(instances) {
+		return nil, fmt.Errorf("tfsymbol: got %d predictions for %d instances",
+			len(data.Predictions), len(instances))
+	}
+	return data.Predictions, nil
 }
 
 type (
@@ -118,9 +342,19 @@ type (
 	predictResponse struct {
 		Predictions [][]float32 `json:"predictions"`
 	}
+	modelStatusResponse struct {
+		ModelVersionStatus []struct {
+			State string `json:"state"`
+		} `json:"model_version_status"`
+	}
 	options struct {
 		client  HTTPDoer
 		baseURL *url.URL
 		model   string
+		topK    int
+
+		batchWindow  time.Duration
+		batchSize    int
+		batchTimeout time.Duration
 	}
 )
@@ -2,28 +2,75 @@ package tfsymbol
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"image"
 	"os"
+	"sort"
+
+	"giautm.dev/captcha/engine"
 )
 
 // Labels is a slice of strings that represents the labels of the model.
 type Labels []string
 
+var (
+	ErrNoAllowedLabel = errors.New("tfsymbol: no label is allowed")
+)
+
 // BestMatch returns the label with the highest probability.
 func (s Labels) BestMatch(probabilities []float32) (string, error) {
+	return s.BestAllowedMatch(probabilities, nil)
+}
+
+// BestAllowedMatch returns the allowed label with the highest probability,
+// every label is allowed if allowed is nil.
+func (s Labels) BestAllowedMatch(probabilities []float32, allowed engine.SymbolFilter) (string, error) {
 	if len(s) != len(probabilities) {
 		return "", fmt.Errorf("tfsymbol: length mismatch between labels and probabilities")
 	}
-	bestIdx := 0
+	bestIdx := -1
 	for i, p := range probabilities {
-		if p > probabilities[bestIdx] {
+		if allowed != nil && !allowed(s[i]) {
+			continue
+		}
+		if bestIdx < 0 || p > probabilities[bestIdx] {
 			bestIdx = i
 		}
 	}
+	if bestIdx < 0 {
+		return "", ErrNoAllowedLabel
+	}
 	return s[bestIdx], nil
 }
 
+// TopK returns the k labels with the highest probabilities,
+// sorted from the most to the least probable.
+func (s Labels) TopK(probabilities []float32, k int) ([]engine.SymbolCandidate, error) {
+	return s.TopKAllowed(probabilities, k, nil)
+}
+
+// TopKAllowed is TopK restricted to the allowed labels,
+// every label is allowed if allowed is nil.
+func (s Labels) TopKAllowed(probabilities []float32, k int, allowed engine.SymbolFilter) ([]engine.SymbolCandidate, error) {
+	if len(s) != len(probabilities) {
+		return nil, fmt.Errorf("tfsymbol: length mismatch between labels and probabilities")
+	}
+	candidates := make([]engine.SymbolCandidate, 0, len(s))
+	for i, p := range probabilities {
+		if allowed == nil || allowed(s[i]) {
+			candidates = append(candidates, engine.SymbolCandidate{Symbol: s[i], Probability: p})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, ErrNoAllowedLabel
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Probability > candidates[j].Probability
+	})
+	return candidates[:min(max(k, 1), len(candidates))], nil
+}
+
 // ReadLabels reads the labels from a file and returns them as a slice of strings.
 func ReadLabels(labelsFile string) (Labels, error) {
 	// Read the string from labelsFile, which